@@ -0,0 +1,157 @@
+package metis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// apiKeyCacheTTL is how long a resolved api key is used before its source is asked again.
+const apiKeyCacheTTL = 30 * time.Second
+
+var errEmptyAPIKey = errors.New("empty api key")
+
+// APIKeySource resolves the api key sent with the uploads. The key is resolved again
+// every 30 seconds, and right away once the endpoint rejected it.
+type APIKeySource interface {
+	APIKey(ctx context.Context) (string, error)
+}
+
+// APIKeyFunc is a function resolving the api key, for example from a secret manager.
+type APIKeyFunc func(ctx context.Context) (string, error)
+
+// APIKey calls f(ctx).
+func (f APIKeyFunc) APIKey(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+type staticAPIKey string
+
+func (k staticAPIKey) APIKey(context.Context) (string, error) {
+	return string(k), nil
+}
+
+// StaticAPIKey returns a source of a fixed key.
+func StaticAPIKey(key string) APIKeySource {
+	return staticAPIKey(key)
+}
+
+// EnvAPIKey returns a source reading the key from the environment variable name.
+func EnvAPIKey(name string) APIKeySource {
+	return APIKeyFunc(func(context.Context) (string, error) {
+		key := os.Getenv(name)
+		if key == "" {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return key, nil
+	})
+}
+
+// FileAPIKey returns a source reading the key from the file at path, such as a mounted
+// Kubernetes secret. The file is read again when its modification time or size changes,
+// surrounding whitespace is ignored.
+func FileAPIKey(path string) APIKeySource {
+	return &fileAPIKey{path: path}
+}
+
+type fileAPIKey struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	key     string
+}
+
+func (f *fileAPIKey) APIKey(context.Context) (string, error) {
+	// Stat follows the symlinks swapped by secret remounts
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.key != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.key, nil
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.key = string(bytes.TrimSpace(b))
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return f.key, nil
+}
+
+// APIKeyError is returned when the api key can't be resolved, no upload is attempted.
+type APIKeyError struct {
+	Err error
+}
+
+func (e *APIKeyError) Error() string {
+	return "metis: resolving the api key: " + e.Err.Error()
+}
+
+func (e *APIKeyError) Unwrap() error {
+	return e.Err
+}
+
+// apiKeyResolver caches the key of source and remembers the last key rejected by the endpoint.
+type apiKeyResolver struct {
+	source APIKeySource
+
+	mu       sync.Mutex
+	key      string
+	expires  time.Time
+	rejected string
+}
+
+func newAPIKeyResolver(source APIKeySource) *apiKeyResolver {
+	return &apiKeyResolver{source: source}
+}
+
+// get returns the cached key, or resolves it again once expired. A rejected key isn't cached,
+// the paused uploads and spool replays look for a new key each time.
+func (r *apiKeyResolver) get(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.key != "" && r.key != r.rejected && time.Now().Before(r.expires) {
+		return r.key, nil
+	}
+	key, err := r.source.APIKey(ctx)
+	if err == nil && key == "" {
+		err = errEmptyAPIKey
+	}
+	if err != nil {
+		return "", &APIKeyError{Err: err}
+	}
+	r.key = key
+	r.expires = time.Now().Add(apiKeyCacheTTL)
+	return key, nil
+}
+
+// reject remembers key as rejected by the endpoint, the next get resolves the key again.
+func (r *apiKeyResolver) reject(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rejected = key
+	if r.key == key {
+		r.expires = time.Time{}
+	}
+}
+
+// paused reports whether the current key was rejected, uploads wait for a new key.
+func (r *apiKeyResolver) paused(ctx context.Context) (bool, error) {
+	key, err := r.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return key == r.rejected, nil
+}
@@ -0,0 +1,161 @@
+package metis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileAPIKeyRotation(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	exp, mm := newTestExporter(t, WithAPIKeySource(FileAPIKey(path)), WithRetry(RetryConfig{}), WithErrorHandler(NopErrorHandler()))
+	mm.acceptAPIKey("rotated-key")
+
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	// the rejected key is resolved again at the next upload
+	if err := os.WriteFile(path, []byte("rotated-key\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Errorf("ExportSpans() with the rotated key error = %v", err)
+	}
+	if got := len(mm.requests()); got != 2 {
+		t.Errorf("expected 2 requests, got %d", got)
+	}
+}
+
+func TestRejectedAPIKeyPausesUploads(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithAPIKeySource(StaticAPIKey("invalid-key")), WithRetry(RetryConfig{}), WithErrorHandler(NopErrorHandler()))
+	mm.acceptAPIKey("valid-key")
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	// the rejection is reported once, the same key isn't sent again
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Errorf("expected no error while paused, got %v", err)
+	}
+	if got := len(mm.requests()); got != 1 {
+		t.Errorf("expected 1 request, got %d", got)
+	}
+	if got := exp.Stats().Dropped.ExportFailed; got != 2 {
+		t.Errorf("expected 2 failed spans, got %d", got)
+	}
+}
+
+func TestPausedAPIKeySpoolsBatches(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	dir := t.TempDir()
+	exp, mm := newTestExporter(t,
+		WithAPIKeySource(FileAPIKey(path)),
+		WithSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour}),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(NopErrorHandler()),
+	)
+	mm.acceptAPIKey("rotated-key")
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	// kept for the next key
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Errorf("expected no error while paused, got %v", err)
+	}
+	if got := spooled(t, dir); got != 1 {
+		t.Errorf("expected the batch exported while paused to be spooled, got %d spooled batches", got)
+	}
+
+	if err := os.WriteFile(path, []byte("rotated-key\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Errorf("ExportSpans() with the rotated key error = %v", err)
+	}
+	// the spool is replayed once the rotated key is accepted
+	if got := waitForRequests(mm, 3); len(got) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(got))
+	}
+	if got := exp.Stats().Dropped.ExportFailed; got != 1 {
+		t.Errorf("expected only the rejected span to fail, got %d", got)
+	}
+}
+
+func TestAPIKeyFuncCached(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	exp, mm := newTestExporter(t, WithAPIKeySource(APIKeyFunc(func(context.Context) (string, error) {
+		calls.Add(1)
+		return "callback-key", nil
+	})))
+	mm.acceptAPIKey("callback-key")
+	for i := 0; i < 3; i++ {
+		if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+	}
+	if got := len(mm.requests()); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected the key to be resolved once, got %d calls", got)
+	}
+}
+
+func TestAPIKeyError(t *testing.T) {
+	t.Parallel()
+	errVault := errors.New("vault unreachable")
+	tests := []struct {
+		name    string
+		source  APIKeySource
+		wantErr error
+	}{
+		{
+			name: "callback error",
+			source: APIKeyFunc(func(context.Context) (string, error) {
+				return "", errVault
+			}),
+			wantErr: errVault,
+		},
+		{name: "empty key", source: StaticAPIKey(""), wantErr: errEmptyAPIKey},
+		{name: "missing file", source: FileAPIKey(filepath.Join(t.TempDir(), "missing")), wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		exp, mm := newTestExporter(t, WithAPIKeySource(tt.source), WithRetry(RetryConfig{}), WithErrorHandler(NopErrorHandler()))
+		err := exp.ExportSpans(context.Background(), newTestSpans(1))
+		var keyErr *APIKeyError
+		if !errors.As(err, &keyErr) || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected an APIKeyError wrapping %v, got %v", tt.name, tt.wantErr, err)
+		}
+		if got := exp.Stats().Dropped.ExportFailed; got != 1 {
+			t.Errorf("%s: expected 1 failed span, got %d", tt.name, got)
+		}
+		if got := len(mm.requests()); got != 0 {
+			t.Errorf("%s: expected no request without a key, got %d", tt.name, got)
+		}
+	}
+}
+
+func TestEnvAPIKey(t *testing.T) {
+	const name = "METIS_TEST_ROTATING_API_KEY"
+	source := EnvAPIKey(name)
+	if _, err := source.APIKey(context.Background()); err == nil {
+		t.Error("expected an error without the environment variable")
+	}
+	t.Setenv(name, "env-key")
+	if key, err := source.APIKey(context.Background()); err != nil || key != "env-key" {
+		t.Errorf("APIKey() = %q, %v, want env-key", key, err)
+	}
+}
@@ -0,0 +1,153 @@
+package metis
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// TraceAssemblyConfig configures the trace assembler, which holds spans by trace ID until
+// the local root span of their trace ends, so a trace is exported in a single batch.
+//
+// A trace is released when its local root span ends, when its first span has been held
+// for Timeout, or early when MaxSpans is reached, oldest trace first. Children ending
+// after their trace was released are late, they are exported as soon as they arrive.
+// A released trace is only split across batches when it alone exceeds the batch byte limit.
+type TraceAssemblyConfig struct {
+	// Timeout is the maximum time the spans of a trace are held.
+	Timeout time.Duration
+	// MaxSpans is the maximum number of spans held across all traces.
+	MaxSpans int
+}
+
+// DefaultTraceAssemblyConfig is the trace assembly configuration used for zero fields.
+var DefaultTraceAssemblyConfig = TraceAssemblyConfig{
+	Timeout:  10 * time.Second,
+	MaxSpans: 10000,
+}
+
+// minReleaseInterval bounds how often the held traces are checked for the timeout.
+const minReleaseInterval = time.Millisecond
+
+// heldTrace holds the spans of a trace waiting for its local root.
+type heldTrace struct {
+	id    oteltrace.TraceID
+	spans []trace.ReadOnlySpan
+	since time.Time
+	// elem is the element of the trace in traceAssembler.order.
+	elem *list.Element
+}
+
+// traceAssembler groups spans by trace. It is safe for concurrent use.
+type traceAssembler struct {
+	cfg TraceAssemblyConfig
+
+	mu     sync.Mutex
+	traces map[oteltrace.TraceID]*heldTrace
+	// order holds the held traces, oldest first.
+	order *list.List
+	held  int
+	// released remembers released traces to recognize late children.
+	released *traceSet
+}
+
+func newTraceAssembler(cfg TraceAssemblyConfig) *traceAssembler {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTraceAssemblyConfig.Timeout
+	}
+	if cfg.MaxSpans <= 0 {
+		cfg.MaxSpans = DefaultTraceAssemblyConfig.MaxSpans
+	}
+	return &traceAssembler{
+		cfg:      cfg,
+		traces:   map[oteltrace.TraceID]*heldTrace{},
+		order:    list.New(),
+		released: newTraceSet(maxTrackedTraces),
+	}
+}
+
+// releaseInterval returns how often the held traces are checked for the timeout.
+func (a *traceAssembler) releaseInterval() time.Duration {
+	if interval := a.cfg.Timeout / 4; interval > minReleaseInterval {
+		return interval
+	}
+	return minReleaseInterval
+}
+
+// isLocalRoot reports whether span is the root of its trace in this process.
+func isLocalRoot(span trace.ReadOnlySpan) bool {
+	return !span.Parent().IsValid() || span.Parent().IsRemote()
+}
+
+// add holds spans and returns the traces ready to be exported, each one with its local root first.
+func (a *traceAssembler) add(spans []trace.ReadOnlySpan, now time.Time) [][]trace.ReadOnlySpan {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ready [][]trace.ReadOnlySpan
+	for _, span := range spans {
+		id := span.SpanContext().TraceID()
+		if a.released.contains(id) {
+			ready = append(ready, []trace.ReadOnlySpan{span})
+			continue
+		}
+		t, ok := a.traces[id]
+		if !ok {
+			t = &heldTrace{id: id, since: now}
+			t.elem = a.order.PushBack(t)
+			a.traces[id] = t
+		}
+		a.held++
+		if isLocalRoot(span) {
+			t.spans = append([]trace.ReadOnlySpan{span}, t.spans...)
+			ready = append(ready, a.release(t))
+			continue
+		}
+		t.spans = append(t.spans, span)
+	}
+	ready = append(ready, a.expire(now)...)
+	for a.held > a.cfg.MaxSpans {
+		ready = append(ready, a.release(a.order.Front().Value.(*heldTrace)))
+	}
+	return ready
+}
+
+// expired returns the traces held for longer than the timeout.
+func (a *traceAssembler) expired(now time.Time) [][]trace.ReadOnlySpan {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.expire(now)
+}
+
+func (a *traceAssembler) expire(now time.Time) [][]trace.ReadOnlySpan {
+	var ready [][]trace.ReadOnlySpan
+	for e := a.order.Front(); e != nil; e = a.order.Front() {
+		t := e.Value.(*heldTrace)
+		if now.Sub(t.since) < a.cfg.Timeout {
+			break
+		}
+		ready = append(ready, a.release(t))
+	}
+	return ready
+}
+
+// releaseAll returns all held traces.
+func (a *traceAssembler) releaseAll() [][]trace.ReadOnlySpan {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ready [][]trace.ReadOnlySpan
+	for e := a.order.Front(); e != nil; e = a.order.Front() {
+		ready = append(ready, a.release(e.Value.(*heldTrace)))
+	}
+	return ready
+}
+
+func (a *traceAssembler) release(t *heldTrace) []trace.ReadOnlySpan {
+	a.order.Remove(t.elem)
+	delete(a.traces, t.id)
+	a.released.add(t.id)
+	a.held -= len(t.spans)
+	return t.spans
+}
@@ -0,0 +1,159 @@
+package metis
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// newTraceSpan returns an ended span of trace t, a local root when parent is 0.
+func newTraceSpan(name string, t, id, parent byte) trace.ReadOnlySpan {
+	stub := tracetest.SpanStub{
+		Name: name,
+		SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID: oteltrace.TraceID{t},
+			SpanID:  oteltrace.SpanID{id},
+		}),
+	}
+	if parent != 0 {
+		stub.Parent = oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID: oteltrace.TraceID{t},
+			SpanID:  oteltrace.SpanID{parent},
+		})
+	}
+	return stub.Snapshot()
+}
+
+func TestTraceAssemblyExportsTraceAsOneBatch(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithTraceAssembly(TraceAssemblyConfig{Timeout: time.Hour}))
+	ctx := context.Background()
+
+	if err := exp.ExportSpans(ctx, []trace.ReadOnlySpan{
+		newTraceSpan("query-1", 1, 2, 1),
+		newTraceSpan("other-query", 2, 2, 1),
+	}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if err := exp.ExportSpans(ctx, []trace.ReadOnlySpan{newTraceSpan("query-2", 1, 3, 1)}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 0 {
+		t.Fatalf("expected spans to be held until the root ends, got %v", got)
+	}
+	if err := exp.ExportSpans(ctx, []trace.ReadOnlySpan{newTraceSpan("root", 1, 1, 0)}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(got))
+	}
+	root := strings.Index(got[0], `"root"`)
+	if root < 0 || root > strings.Index(got[0], "query-1") || !strings.Contains(got[0], "query-2") {
+		t.Errorf("expected the trace with its root first, got %s", got[0])
+	}
+	if strings.Contains(got[0], "other-query") {
+		t.Errorf("expected other traces to be held")
+	}
+
+	// late children are exported right away
+	if err := exp.ExportSpans(ctx, []trace.ReadOnlySpan{newTraceSpan("late", 1, 4, 1)}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 2 || !strings.Contains(got[1], "late") {
+		t.Errorf("expected the late child to be exported, got %v", got)
+	}
+
+	// shutdown exports the held traces
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 3 || !strings.Contains(got[2], "other-query") {
+		t.Errorf("expected the held trace to be exported on shutdown, got %v", got)
+	}
+}
+
+func TestTraceAssemblyTimeout(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithTraceAssembly(TraceAssemblyConfig{Timeout: 20 * time.Millisecond}))
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{newTraceSpan("orphan", 1, 2, 1)}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(mm.requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := mm.requests(); len(got) != 1 || !strings.Contains(got[0], "orphan") {
+		t.Errorf("expected the timed out trace to be exported, got %v", got)
+	}
+}
+
+func TestTraceAssemblyTinyTimeout(t *testing.T) {
+	t.Parallel()
+	for _, opts := range [][]Option{
+		{WithTraceAssembly(TraceAssemblyConfig{Timeout: 1})},
+		{WithTraceAssembly(TraceAssemblyConfig{}), WithMaxExportDelay(time.Nanosecond)},
+	} {
+		// a release interval of Timeout/4 would be zero
+		exp, mm := newTestExporter(t, opts...)
+		if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{newTraceSpan("orphan", 1, 2, 1)}); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for len(mm.requests()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := mm.requests(); len(got) != 1 || !strings.Contains(got[0], "orphan") {
+			t.Errorf("expected the timed out trace to be exported, got %v", got)
+		}
+	}
+}
+
+func TestTraceAssemblyMaxSpans(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithTraceAssembly(TraceAssemblyConfig{Timeout: time.Hour, MaxSpans: 2}))
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{
+		newTraceSpan("first", 1, 2, 1),
+		newTraceSpan("second", 2, 2, 1),
+		newTraceSpan("third", 3, 2, 1),
+	}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 1 || !strings.Contains(got[0], "first") || strings.Contains(got[0], "second") {
+		t.Errorf("expected the oldest trace to be released, got %v", got)
+	}
+}
+
+func TestTraceAssemblyKeepsTraceInOneBatch(t *testing.T) {
+	t.Parallel()
+	span := newTraceSpan("a-root", 1, 1, 0)
+	encoded, err := (&spanEncoder{}).encode(span)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	exp, mm := newTestExporter(t,
+		WithTraceAssembly(TraceAssemblyConfig{Timeout: time.Hour}),
+		// room for two and a half spans
+		WithBatchByteLimit(len(encoded)*5/2),
+	)
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{
+		newTraceSpan("b-child", 2, 2, 1),
+		span,
+		newTraceSpan("b-root", 2, 1, 0),
+	}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(got))
+	}
+	if !strings.Contains(got[1], "b-root") || !strings.Contains(got[1], "b-child") {
+		t.Errorf("expected trace b in a single batch, got %v", got)
+	}
+}
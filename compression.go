@@ -0,0 +1,111 @@
+package metis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the encoding applied to export request bodies.
+type Compression int
+
+const (
+	// NoCompression sends request bodies as is.
+	NoCompression Compression = iota
+	// GzipCompression compresses request bodies with gzip.
+	GzipCompression
+	// ZstdCompression compresses request bodies with zstd.
+	ZstdCompression
+)
+
+// contentEncoding returns the Content-Encoding header value of c.
+func (c Compression) contentEncoding() string {
+	switch c {
+	case GzipCompression:
+		return "gzip"
+	case ZstdCompression:
+		return "zstd"
+	}
+	return ""
+}
+
+// compressWriter is a streaming compressor.
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func (c Compression) newWriter(w io.Writer) (compressWriter, error) {
+	switch c {
+	case GzipCompression:
+		return gzip.NewWriter(w), nil
+	case ZstdCompression:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unknown compression %d", c)
+}
+
+// compress returns p compressed with c.
+func (c Compression) compress(p []byte) ([]byte, error) {
+	if c == NoCompression {
+		return p, nil
+	}
+	var buf bytes.Buffer
+	w, err := c.newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressedSizer measures the compressed size of a batch while spans are added to it.
+type compressedSizer struct {
+	w       compressWriter
+	counter *countingWriter
+}
+
+func newCompressedSizer(c Compression) (*compressedSizer, error) {
+	counter := &countingWriter{}
+	w, err := c.newWriter(counter)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedSizer{w: w, counter: counter}, nil
+}
+
+// add compresses p and returns the number of bytes it added to the batch.
+func (s *compressedSizer) add(p []byte) (int, error) {
+	before := s.counter.n
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	if err := s.w.Flush(); err != nil {
+		return 0, err
+	}
+	return s.counter.n - before, nil
+}
+
+// reset starts measuring a new batch.
+func (s *compressedSizer) reset() {
+	s.counter.n = 0
+	s.w.Reset(s.counter)
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
@@ -50,7 +50,7 @@ func main() {
 			resp.Body.Close()
 		}
 		time.Sleep(2 * time.Second)
-		// send shutdown request
+		// send shutdown request, the web services flush their spans
 		for _, url := range urls {
 			log.Printf("sending shutdown request to %s", url)
 			resp, err := http.Get(fmt.Sprintf("%s/shutdown", url))
@@ -9,18 +9,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	metis "github.com/metis-data/go-interceptor"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/sdk/trace"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-var tp *trace.TracerProvider
-
 type User struct {
 	ID   int
 	Name string
@@ -29,25 +26,18 @@ type User struct {
 func main() {
 	log.Printf("starting web server")
 
-	// create a new metis tracer provider
-	var tp *trace.TracerProvider
-	var err error
-	tp, err = metis.NewTracerProvider()
+	// Start metis tracing, the spans are flushed on SIGTERM and SIGINT
+	shutdown, err := metis.Start(context.Background(), metis.WithShutdownOnSignal(5*time.Second))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	otel.SetTracerProvider(tp)
+	defer shutdown(context.Background())
 
 	// Create a new gorilla/mux router
 	router := mux.NewRouter()
 
 	router.HandleFunc("/", getRoot)
-	router.HandleFunc("/shutdown", shutdownHandler)
+	router.HandleFunc("/shutdown", shutdownHandler(shutdown))
 
 	// Wrap the router with the metis handler
 	metisRouter, err := metis.WrapGorillaMuxRouter(router)
@@ -70,9 +60,14 @@ func main() {
 	}
 }
 
-func shutdownHandler(w http.ResponseWriter, r *http.Request) {
-	if err := tp.Shutdown(context.Background()); err != nil {
-		log.Fatal(err)
+// shutdownHandler flushes the spans for the e2e collector, the shutdown of metis.Start can be
+// called again on exit.
+func shutdownHandler(shutdown func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print("Shutdown Server")
+		if err := shutdown(context.Background()); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
 
@@ -9,17 +9,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ido50/sqlz"
 	_ "github.com/lib/pq"
 	metis "github.com/metis-data/go-interceptor"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/sdk/trace"
 )
 
-var tp *trace.TracerProvider
-
 type User struct {
 	ID   int
 	Name string
@@ -28,23 +25,17 @@ type User struct {
 func main() {
 	log.Printf("starting web server")
 
-	// Create a new metis tracer provider
-	var err error
-	tp, err = metis.NewTracerProvider()
+	// Start metis tracing, the spans are flushed on SIGTERM and SIGINT
+	shutdown, err := metis.Start(context.Background(), metis.WithShutdownOnSignal(5*time.Second))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	otel.SetTracerProvider(tp)
+	defer shutdown(context.Background())
 
 	// Create a new gorilla/mux router
 	router := mux.NewRouter()
-	router.HandleFunc("/", metis.WrapHandlerFunc(getRoot, "/"))                         // Wrap each handler with the metis handler
-	router.HandleFunc("/shutdown", metis.WrapHandlerFunc(shutdownHandler, "/shutdown")) // Wrap each handler with the metis handler
+	router.HandleFunc("/", metis.WrapHandlerFunc(getRoot, "/"))                                   // Wrap each handler with the metis handler
+	router.HandleFunc("/shutdown", metis.WrapHandlerFunc(shutdownHandler(shutdown), "/shutdown")) // Wrap each handler with the metis handler
 	// Wrap the router with the metis handler
 	handler := metis.NewHandler(router, "web-go-sqlz")
 
@@ -63,9 +54,14 @@ func main() {
 	}
 }
 
-func shutdownHandler(w http.ResponseWriter, r *http.Request) {
-	if err := tp.Shutdown(context.Background()); err != nil {
-		log.Fatal(err)
+// shutdownHandler flushes the spans for the e2e collector, the shutdown of metis.Start can be
+// called again on exit.
+func shutdownHandler(shutdown func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print("Shutdown Server")
+		if err := shutdown(context.Background()); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
 
@@ -9,33 +9,24 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 	metis "github.com/metis-data/go-interceptor"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/sdk/trace"
 )
 
-var tp *trace.TracerProvider
-
 func main() {
 	log.Printf("starting web server")
-	// create a new metis tracer provider
-	var err error
-	tp, err = metis.NewTracerProvider()
+	// Start metis tracing, the spans are flushed on SIGTERM and SIGINT
+	shutdown, err := metis.Start(context.Background(), metis.WithShutdownOnSignal(5*time.Second))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	otel.SetTracerProvider(tp)
+	defer shutdown(context.Background())
 
 	mux := metis.NewServeMux() // use metis.NewServeMux() instead of http.NewServeMux()
 	mux.HandleFunc("/", getRoot)
-	mux.HandleFunc("/shutdown", shutdownHandler)
+	mux.HandleFunc("/shutdown", shutdownHandler(shutdown))
 	// Wrap the router with the metis handler
 	handler := metis.NewHandler(mux, "my-web-service")
 
@@ -53,10 +44,14 @@ func main() {
 	}
 }
 
-func shutdownHandler(w http.ResponseWriter, r *http.Request) {
-	log.Print("Shutdown Server")
-	if err := tp.Shutdown(context.Background()); err != nil {
-		log.Fatal(err)
+// shutdownHandler flushes the spans for the e2e collector, the shutdown of metis.Start can be
+// called again on exit.
+func shutdownHandler(shutdown func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print("Shutdown Server")
+		if err := shutdown(context.Background()); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
 
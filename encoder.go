@@ -0,0 +1,300 @@
+package metis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// spanEncoder encodes spans into the Metis payload format, a JSON array of PayloadSpan,
+// without going through the payload structs. Any change of the output must bump
+// PayloadSchemaVersion. It is not safe for concurrent use.
+type spanEncoder struct {
+	buf []byte
+	// resource and resourceJSON cache the encoding of the last seen resource,
+	// spans of a tracer provider all share the same one.
+	resource     *resource.Resource
+	resourceJSON []byte
+}
+
+// encode returns the JSON encoding of span. The returned slice is owned by the caller.
+func (e *spanEncoder) encode(span trace.ReadOnlySpan) ([]byte, error) {
+	b, err := e.appendSpan(e.buf[:0], span)
+	if err != nil {
+		return nil, err
+	}
+	e.buf = b
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out, nil
+}
+
+// encodeBatch joins encoded spans into a single JSON array.
+func encodeBatch(spans [][]byte) []byte {
+	size := len(spans) + 1
+	for _, span := range spans {
+		size += len(span)
+	}
+	b := make([]byte, 0, size)
+	b = append(b, '[')
+	for i, span := range spans {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, span...)
+	}
+	return append(b, ']')
+}
+
+func (e *spanEncoder) appendSpan(b []byte, span trace.ReadOnlySpan) ([]byte, error) {
+	var err error
+	b = append(b, `{"Name":`...)
+	b = appendString(b, span.Name())
+	b = append(b, `,"SpanContext":`...)
+	b = appendSpanContext(b, span.SpanContext())
+	b = append(b, `,"Parent":`...)
+	b = appendSpanContext(b, span.Parent())
+	b = append(b, `,"SpanKind":`...)
+	b = strconv.AppendInt(b, int64(span.SpanKind()), 10)
+	b = append(b, `,"StartTime":`...)
+	b = appendTime(b, span.StartTime())
+	b = append(b, `,"EndTime":`...)
+	b = appendTime(b, span.EndTime())
+	b = append(b, `,"Attributes":`...)
+	if b, err = appendAttributes(b, span.Attributes()); err != nil {
+		return nil, err
+	}
+	b = append(b, `,"Events":`...)
+	if b, err = appendEvents(b, span.Events()); err != nil {
+		return nil, err
+	}
+	b = append(b, `,"Links":`...)
+	if b, err = appendLinks(b, span.Links()); err != nil {
+		return nil, err
+	}
+	b = append(b, `,"Status":`...)
+	if b, err = appendStatus(b, span.Status()); err != nil {
+		return nil, err
+	}
+	b = append(b, `,"DroppedAttributes":`...)
+	b = strconv.AppendInt(b, int64(span.DroppedAttributes()), 10)
+	b = append(b, `,"DroppedEvents":`...)
+	b = strconv.AppendInt(b, int64(span.DroppedEvents()), 10)
+	b = append(b, `,"DroppedLinks":`...)
+	b = strconv.AppendInt(b, int64(span.DroppedLinks()), 10)
+	b = append(b, `,"ChildSpanCount":`...)
+	b = strconv.AppendInt(b, int64(span.ChildSpanCount()), 10)
+	b = append(b, `,"Resource":`...)
+	if b, err = e.appendResource(b, span.Resource()); err != nil {
+		return nil, err
+	}
+	scope := span.InstrumentationScope()
+	b = append(b, `,"InstrumentationLibrary":{"Name":`...)
+	b = appendString(b, scope.Name)
+	b = append(b, `,"Version":`...)
+	b = appendString(b, scope.Version)
+	b = append(b, `,"SchemaURL":`...)
+	b = appendString(b, scope.SchemaURL)
+	return append(b, "}}"...), nil
+}
+
+func (e *spanEncoder) appendResource(b []byte, res *resource.Resource) ([]byte, error) {
+	if res == nil {
+		return append(b, "null"...), nil
+	}
+	if res != e.resource {
+		var err error
+		e.resourceJSON, err = appendAttributes(nil, res.Attributes())
+		if err != nil {
+			return nil, err
+		}
+		if e.resourceJSON == nil || string(e.resourceJSON) == "null" {
+			e.resourceJSON = []byte("[]")
+		}
+		e.resource = res
+	}
+	return append(b, e.resourceJSON...), nil
+}
+
+func appendSpanContext(b []byte, sc oteltrace.SpanContext) []byte {
+	traceID := sc.TraceID()
+	spanID := sc.SpanID()
+	b = append(b, `{"TraceID":"`...)
+	b = appendHex(b, traceID[:])
+	b = append(b, `","SpanID":"`...)
+	b = appendHex(b, spanID[:])
+	b = append(b, `","TraceFlags":"`...)
+	b = appendHex(b, []byte{byte(sc.TraceFlags())})
+	b = append(b, `","TraceState":`...)
+	b = appendString(b, sc.TraceState().String())
+	b = append(b, `,"Remote":`...)
+	b = strconv.AppendBool(b, sc.IsRemote())
+	return append(b, '}')
+}
+
+func appendHex(b []byte, p []byte) []byte {
+	n := len(b)
+	b = append(b, make([]byte, hex.EncodedLen(len(p)))...)
+	hex.Encode(b[n:], p)
+	return b
+}
+
+func appendTime(b []byte, t time.Time) []byte {
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"')
+}
+
+func appendStatus(b []byte, status trace.Status) ([]byte, error) {
+	var code string
+	switch status.Code {
+	case codes.Unset:
+		code = "Unset"
+	case codes.Error:
+		code = "Error"
+	case codes.Ok:
+		code = "Ok"
+	default:
+		return nil, fmt.Errorf("invalid code: %d", status.Code)
+	}
+	b = append(b, `{"Code":`...)
+	b = appendString(b, code)
+	b = append(b, `,"Description":`...)
+	b = appendString(b, status.Description)
+	return append(b, '}'), nil
+}
+
+func appendEvents(b []byte, events []trace.Event) ([]byte, error) {
+	if events == nil {
+		return append(b, "null"...), nil
+	}
+	var err error
+	b = append(b, '[')
+	for i, event := range events {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"Name":`...)
+		b = appendString(b, event.Name)
+		b = append(b, `,"Attributes":`...)
+		if b, err = appendAttributes(b, event.Attributes); err != nil {
+			return nil, err
+		}
+		b = append(b, `,"DroppedAttributeCount":`...)
+		b = strconv.AppendInt(b, int64(event.DroppedAttributeCount), 10)
+		b = append(b, `,"Time":`...)
+		b = appendTime(b, event.Time)
+		b = append(b, '}')
+	}
+	return append(b, ']'), nil
+}
+
+func appendLinks(b []byte, links []trace.Link) ([]byte, error) {
+	if links == nil {
+		return append(b, "null"...), nil
+	}
+	var err error
+	b = append(b, '[')
+	for i, link := range links {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"SpanContext":`...)
+		b = appendSpanContext(b, link.SpanContext)
+		b = append(b, `,"Attributes":`...)
+		if b, err = appendAttributes(b, link.Attributes); err != nil {
+			return nil, err
+		}
+		b = append(b, `,"DroppedAttributeCount":`...)
+		b = strconv.AppendInt(b, int64(link.DroppedAttributeCount), 10)
+		b = append(b, '}')
+	}
+	return append(b, ']'), nil
+}
+
+func appendAttributes(b []byte, attrs []attribute.KeyValue) ([]byte, error) {
+	if attrs == nil {
+		return append(b, "null"...), nil
+	}
+	var err error
+	b = append(b, '[')
+	for i, attr := range attrs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"Key":`...)
+		b = appendString(b, string(attr.Key))
+		b = append(b, `,"Value":{"Type":`...)
+		b = appendString(b, attr.Value.Type().String())
+		b = append(b, `,"Value":`...)
+		if b, err = appendValue(b, attr.Value); err != nil {
+			return nil, err
+		}
+		b = append(b, "}}"...)
+	}
+	return append(b, ']'), nil
+}
+
+func appendValue(b []byte, v attribute.Value) ([]byte, error) {
+	switch v.Type() {
+	case attribute.BOOL:
+		return strconv.AppendBool(b, v.AsBool()), nil
+	case attribute.INT64:
+		return strconv.AppendInt(b, v.AsInt64(), 10), nil
+	case attribute.FLOAT64:
+		return appendFloat(b, v.AsFloat64())
+	case attribute.STRING:
+		return appendString(b, v.AsString()), nil
+	}
+	// slices are rare, leave them to encoding/json.
+	p, err := json.Marshal(v.AsInterface())
+	if err != nil {
+		return nil, err
+	}
+	return append(b, p...), nil
+}
+
+// appendFloat appends f the way encoding/json does.
+func appendFloat(b []byte, f float64) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("unsupported float value: %v", f)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+// appendString appends s as a JSON string, escaped the way encoding/json does.
+func appendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= utf8.RuneSelf || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			// strings that need escaping are left to encoding/json.
+			p, _ := json.Marshal(s)
+			return append(b, p...)
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"')
+}
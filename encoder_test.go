@@ -0,0 +1,185 @@
+package metis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func newRichTestSpan() tracetest.SpanStub {
+	traceID, _ := oteltrace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := oteltrace.SpanIDFromHex("0102030405060708")
+	parentID, _ := oteltrace.SpanIDFromHex("0807060504030201")
+	traceState, _ := oteltrace.ParseTraceState("metis=balagan")
+	start := time.Date(2023, 6, 16, 10, 11, 12, 123456789, time.UTC)
+	return tracetest.SpanStub{
+		Name: "GET /users/{id}",
+		SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: oteltrace.FlagsSampled,
+			TraceState: traceState,
+		}),
+		Parent: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  parentID,
+			Remote:  true,
+		}),
+		SpanKind:  oteltrace.SpanKindServer,
+		StartTime: start,
+		EndTime:   start.Add(1500 * time.Millisecond),
+		Attributes: []attribute.KeyValue{
+			semconv.HTTPRoute("/users/{id}"),
+			semconv.HTTPStatusCode(200),
+			attribute.Bool("balagan.bool", true),
+			attribute.Float64("balagan.float", 0.000000123),
+			attribute.Float64("balagan.round", 42),
+			attribute.String("db.statement", "SELECT * FROM users WHERE id < 10 AND name = '\"gadol\"\n'"),
+			attribute.StringSlice("balagan.strings", []string{"a", "b"}),
+			attribute.Int64Slice("balagan.ints", []int64{1, 2}),
+			attribute.BoolSlice("balagan.bools", []bool{true}),
+			attribute.Float64Slice("balagan.floats", []float64{1.5}),
+		},
+		Events: []trace.Event{{
+			Name:       "exception",
+			Attributes: []attribute.KeyValue{semconv.ExceptionMessage("לא טוב")},
+			Time:       start.Add(time.Millisecond),
+		}},
+		Links: []trace.Link{{
+			SpanContext:           oteltrace.NewSpanContext(oteltrace.SpanContextConfig{TraceID: traceID, SpanID: parentID}),
+			DroppedAttributeCount: 1,
+		}},
+		Status:            trace.Status{Code: codes.Error, Description: "balagan"},
+		DroppedAttributes: 1,
+		DroppedEvents:     2,
+		DroppedLinks:      3,
+		ChildSpanCount:    4,
+		Resource: resource.NewSchemaless(
+			semconv.ServiceName("metis-go-client"),
+			semconv.ServiceVersion("v0.1.0"),
+		),
+		InstrumentationLibrary: instrumentation.Scope{Name: "balagan", Version: "v1"},
+	}
+}
+
+func TestSpanEncoderMatchesStdouttrace(t *testing.T) {
+	t.Parallel()
+	stubs := tracetest.SpanStubs{
+		newRichTestSpan(),
+		{Name: "empty"},
+	}
+	enc := &spanEncoder{}
+	for _, span := range stubs.Snapshots() {
+		got, err := enc.encode(span)
+		if err != nil {
+			t.Fatalf("encode() error = %v", err)
+		}
+		var want bytes.Buffer
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(&want))
+		if err != nil {
+			t.Fatalf("stdouttrace.New() error = %v", err)
+		}
+		if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{span}); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+		if string(got) != strings.TrimRight(want.String(), "\n") {
+			t.Errorf("encode() mismatch\ngot:  %s\nwant: %s", got, want.String())
+		}
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	t.Parallel()
+	if got := string(encodeBatch([][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)})); got != `[{"a":1},{"b":2}]` {
+		t.Errorf("encodeBatch() = %s", got)
+	}
+	var js []map[string]interface{}
+	spans := newTestSpans(3)
+	enc := &spanEncoder{}
+	var encoded [][]byte
+	for _, span := range spans {
+		p, err := enc.encode(span)
+		if err != nil {
+			t.Fatalf("encode() error = %v", err)
+		}
+		encoded = append(encoded, p)
+	}
+	if err := json.Unmarshal(encodeBatch(encoded), &js); err != nil || len(js) != 3 {
+		t.Errorf("expected a JSON array of 3 spans, got %v, %v", len(js), err)
+	}
+}
+
+func benchmarkSpans(n int) []trace.ReadOnlySpan {
+	stubs := make(tracetest.SpanStubs, n)
+	for i := range stubs {
+		stubs[i] = newRichTestSpan()
+	}
+	return stubs.Snapshots()
+}
+
+// BenchmarkEncodeSpans measures encoding spans into a batch with spanEncoder.
+func BenchmarkEncodeSpans(b *testing.B) {
+	spans := benchmarkSpans(100)
+	enc := &spanEncoder{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded := make([][]byte, 0, len(spans))
+		for _, span := range spans {
+			p, err := enc.encode(span)
+			if err != nil {
+				b.Fatal(err)
+			}
+			encoded = append(encoded, p)
+		}
+		_ = encodeBatch(encoded)
+	}
+}
+
+// BenchmarkEncodeSpansStdouttrace measures the previous encoding, three stdouttrace
+// round-trips per span and a map round-trip of the batch, for comparison.
+func BenchmarkEncodeSpansStdouttrace(b *testing.B) {
+	spans := benchmarkSpans(100)
+	var buf bytes.Buffer
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(&buf))
+	if err != nil {
+		b.Fatal(err)
+	}
+	encode := func(span trace.ReadOnlySpan) string {
+		buf.Reset()
+		if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{span}); err != nil {
+			b.Fatal(err)
+		}
+		return buf.String()
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var res []map[string]interface{}
+		for _, span := range spans {
+			_ = encode(span) // relevance
+			_ = encode(span) // size
+			var spanMap map[string]interface{}
+			if err := json.Unmarshal([]byte(encode(span)), &spanMap); err != nil {
+				b.Fatal(err)
+			}
+			res = append(res, spanMap)
+		}
+		if _, err := json.Marshal(res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package metis
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+func endSpans(tp *trace.TracerProvider, n int) {
+	for i := 0; i < n; i++ {
+		_, span := tp.Tracer("balagan").Start(context.Background(), "gadol")
+		span.End()
+	}
+}
+
+func TestSDKDisabledEnv(t *testing.T) {
+	t.Setenv("OTEL_SDK_DISABLED", "TRUE")
+	t.Setenv("METIS_API_KEY", "")
+	// no api key is needed
+	tp, err := NewTracerProvider()
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	_, span := tp.Tracer("balagan").Start(context.Background(), "gadol")
+	if span.IsRecording() {
+		t.Error("expected a disabled SDK to record no span")
+	}
+	span.End()
+}
+
+func TestTracesSamplerEnv(t *testing.T) {
+	t.Setenv("OTEL_TRACES_SAMPLER", "always_off")
+	tp, mm := newTestTracerProvider(t)
+	endSpans(tp, 3)
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+	if len(mm.spans) != 0 {
+		t.Errorf("expected no export of unsampled spans, got %d requests", len(mm.spans))
+	}
+}
+
+func TestBatchSpanProcessorEnv(t *testing.T) {
+	t.Setenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "2")
+	tp, mm := newTestTracerProvider(t)
+	endSpans(tp, 4)
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+	if len(mm.spans) != 2 {
+		t.Errorf("expected 2 batches of 2 spans, got %d requests", len(mm.spans))
+	}
+}
+
+func TestPropagatorsEnv(t *testing.T) {
+	t.Setenv("OTEL_PROPAGATORS", "baggage,xray")
+	var handled []error
+	newTestTracerProvider(t, WithErrorHandler(ErrorHandlerFunc(func(err error) {
+		handled = append(handled, err)
+	})))
+	if got := otel.GetTextMapPropagator().Fields(); len(got) != 1 || got[0] != "baggage" {
+		t.Errorf("expected the baggage propagator, got fields %v", got)
+	}
+	if len(handled) != 1 || !strings.Contains(handled[0].Error(), "xray") {
+		t.Errorf("expected the unknown propagator to be reported, got %v", handled)
+	}
+}
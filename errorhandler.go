@@ -0,0 +1,35 @@
+package metis
+
+import (
+	"log"
+	"os"
+)
+
+// ErrorHandler handles errors raised while exporting spans.
+type ErrorHandler interface {
+	Handle(err error)
+}
+
+// ErrorHandlerFunc is an adapter to allow the use of ordinary functions as ErrorHandler.
+type ErrorHandlerFunc func(err error)
+
+// Handle calls f(err).
+func (f ErrorHandlerFunc) Handle(err error) {
+	f(err)
+}
+
+// NopErrorHandler returns an ErrorHandler that ignores all errors.
+func NopErrorHandler() ErrorHandler {
+	return ErrorHandlerFunc(func(error) {})
+}
+
+// NewLogErrorHandler returns an ErrorHandler that writes errors to logger.
+// A nil logger writes to stderr.
+func NewLogErrorHandler(logger *log.Logger) ErrorHandler {
+	if logger == nil {
+		logger = log.New(os.Stderr, "metis: ", log.LstdFlags)
+	}
+	return ErrorHandlerFunc(func(err error) {
+		logger.Print(err)
+	})
+}
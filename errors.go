@@ -0,0 +1,34 @@
+package metis
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrUnauthorized is matched by export errors caused by a missing or invalid api key.
+var ErrUnauthorized = errors.New("metis: unauthorized, check the api key")
+
+// ExportError is returned when the Metis endpoint answers an export with a non 2xx status code.
+type ExportError struct {
+	// StatusCode is the http status code of the response.
+	StatusCode int
+	// Body is the beginning of the response body.
+	Body string
+	// RetryAfter is the delay requested by the Retry-After header of 429 and 503 responses.
+	RetryAfter time.Duration
+}
+
+func (e *ExportError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("metis: export failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("metis: export failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Is reports whether the error is an authentication failure when target is ErrUnauthorized.
+func (e *ExportError) Is(target error) bool {
+	return target == ErrUnauthorized &&
+		(e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
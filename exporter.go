@@ -0,0 +1,614 @@
+package metis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// backgroundExportTimeout bounds exports that don't run on behalf of a caller.
+const backgroundExportTimeout = 30 * time.Second
+
+// errExporterStopped is returned by the exports following Shutdown.
+var errExporterStopped = errors.New("metis: exporter is shut down, spans dropped")
+
+// Exporter is a trace.SpanExporter sending relevant spans to Metis in batches
+// limited in bytes.
+//
+// It is safe for concurrent use. Every ExportSpans call builds its own batches,
+// so concurrent calls only share the encoders and sizers pools.
+type Exporter struct {
+	ms                  *metisServer
+	batchByteLimit      int
+	filter              SpanFilter
+	truncatedAttributes []string
+	errorHandler        ErrorHandler
+	retry               RetryConfig
+	compression         Compression
+	compressedLimit     bool
+	format              ExportFormat
+	encoders            sync.Pool
+	// sizers measure the compressed batch size when the limit applies to compressed bodies.
+	sizers sync.Pool
+
+	// mu guards stopped and the registration of exports in flight, counted by exports.
+	// Shutdown waits for them, the lock isn't held while they run.
+	mu      sync.RWMutex
+	stopped bool
+	exports sync.WaitGroup
+	// assembler holds spans by trace when trace assembly is enabled.
+	assembler *traceAssembler
+	// sampler applies tail sampling to assembled traces.
+	sampler *tailSampler
+	// spool keeps the batches that failed to export on disk until they can be replayed.
+	spool *spool
+	// queue holds the batches uploaded by the workers when the export queue is enabled.
+	queue   *exportQueue
+	workers sync.WaitGroup
+	// uploads is done once Shutdown abandons the uploads, the workers upload with it and the
+	// exports in flight are stopped with it.
+	uploads       context.Context
+	cancelUploads context.CancelFunc
+	stats         *exporterStats
+	// done stops the release of timed out traces.
+	done chan struct{}
+	// unauthorizedReported is set once a rejection of the api key was reported, uploads are
+	// paused until the key changes.
+	unauthorizedReported atomic.Bool
+}
+
+// NewExporter returns a new Metis span exporter. It accepts the same options and
+// environment variables as NewTracerProvider, resource and span processor options are ignored.
+//
+// The default span filter keeps the DB spans of HTTP server traces. It learns these traces when
+// their spans start, through the span processor of NewSpanProcessor and NewTracerProvider. With
+// another span processor, it only learns them from the spans exported together, and drops the DB
+// spans exported before the HTTP server span of their trace. Use NewSpanProcessor instead when
+// possible.
+func NewExporter(opts ...Option) (*Exporter, error) {
+	return newExporter(newConfig(append(envOptions(), opts...)))
+}
+
+func newExporter(cfg *config) (*Exporter, error) {
+	if cfg.apiKey == nil {
+		return nil, fmt.Errorf("METIS_API_KEY environment variable not set")
+	}
+	if cfg.batchByteLimit <= 0 {
+		return nil, fmt.Errorf("invalid batch byte limit: %d", cfg.batchByteLimit)
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ms := &metisServer{
+		url:    cfg.url,
+		apiKey: newAPIKeyResolver(cfg.apiKey),
+		client: client,
+	}
+	m := &Exporter{
+		ms:                  ms,
+		batchByteLimit:      cfg.batchByteLimit,
+		filter:              cfg.filter,
+		truncatedAttributes: cfg.truncatedAttributes,
+		errorHandler:        cfg.errorHandler,
+		retry:               cfg.retry.withDefaults(),
+		compression:         cfg.compression,
+		compressedLimit:     cfg.compressedLimit && cfg.compression != NoCompression,
+		format:              cfg.format,
+	}
+	m.uploads, m.cancelUploads = context.WithCancel(context.Background())
+	if m.filter == nil {
+		m.filter = newDefaultFilter()
+	}
+	m.stats, err = newExporterStats(cfg.meterProvider)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.tailSampling != nil {
+		m.sampler = newTailSampler(*cfg.tailSampling)
+		if cfg.traceAssembly == nil {
+			cfg.traceAssembly = &TraceAssemblyConfig{}
+		}
+	}
+	if cfg.traceAssembly != nil {
+		assembly := *cfg.traceAssembly
+		if cfg.maxExportDelay > 0 && (assembly.Timeout <= 0 || assembly.Timeout > cfg.maxExportDelay) {
+			assembly.Timeout = cfg.maxExportDelay
+		}
+		m.assembler = newTraceAssembler(assembly)
+		m.done = make(chan struct{})
+		go m.releaseHeldTraces(m.assembler.releaseInterval())
+	}
+	if cfg.spool != nil {
+		m.spool, err = newSpool(*cfg.spool, ms, m.errorHandler, m.stats)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.exportQueue != nil {
+		m.queue = newExportQueue(*cfg.exportQueue)
+		m.workers.Add(m.queue.cfg.Workers)
+		for i := 0; i < m.queue.cfg.Workers; i++ {
+			go m.uploadQueued()
+		}
+	}
+	// fail early on an unknown format
+	if _, err := m.format.newEncoder(); err != nil {
+		return nil, err
+	}
+	m.encoders.New = func() any {
+		encoder, _ := m.format.newEncoder()
+		return encoder
+	}
+	if m.compressedLimit {
+		// fail early on an unknown compression
+		sizer, err := newCompressedSizer(cfg.compression)
+		if err != nil {
+			return nil, err
+		}
+		m.sizers.Put(sizer)
+	}
+	if cfg.statsReader != nil {
+		cfg.statsReader.exporter.Store(m)
+	}
+	return m, nil
+}
+
+// ExportSpans exports the relevant spans to Metis. Its errors are returned, not reported to the
+// error handler: the batch span processor of the SDK reports them through otel.Handle.
+func (m *Exporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	m.stats.addReceived(len(spans))
+	return m.exportSpans(ctx, spans)
+}
+
+func (m *Exporter) exportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	if !m.startExport() {
+		m.stats.addAfterShutdown(len(spans))
+		return errExporterStopped
+	}
+	defer m.exports.Done()
+	if m.assembler != nil {
+		return m.exportGroups(ctx, m.assembler.add(spans, time.Now()))
+	}
+	return m.exportGroups(ctx, [][]trace.ReadOnlySpan{spans})
+}
+
+// startExport registers an export in flight, unless the exporter is stopped.
+func (m *Exporter) startExport() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.stopped {
+		return false
+	}
+	m.exports.Add(1)
+	return true
+}
+
+// exportGroups exports the relevant spans of groups to metis server, in batches limited to
+// batchByteLimit bytes. The spans of a group are sent in the same batch unless the group
+// alone exceeds the limit. A failed batch doesn't stop the next ones, the first error is returned.
+func (m *Exporter) exportGroups(ctx context.Context, groups [][]trace.ReadOnlySpan) error {
+	ctx, cancel := m.uploadContext(ctx)
+	defer cancel()
+	if m.sampler != nil {
+		sampled := m.sampler.sample(groups)
+		m.stats.addFiltered(spanCount(groups) - spanCount(sampled))
+		groups = sampled
+	}
+	b, err := m.newBatcher()
+	if err != nil {
+		return err
+	}
+	defer m.releaseBatcher(b)
+	encoder := m.encoders.Get().(payloadEncoder)
+	defer m.encoders.Put(encoder)
+
+	if o, ok := m.filter.(startObserver); ok {
+		// show the filter the spans it may not have seen start, without the span processors
+		// of this package the DB spans of an HTTP server span exported along are kept.
+		for _, group := range groups {
+			for _, span := range group {
+				o.OnStart(span)
+			}
+		}
+	}
+	for _, group := range groups {
+		var encodedGroup [][]byte
+		for _, span := range group {
+			if !m.filter.Keep(span) {
+				m.stats.addFiltered(1)
+				continue
+			}
+			encoded, err := encoder.encode(span)
+			if err != nil {
+				return err
+			}
+			encoded, err = m.fitSpan(encoder, span, encoded)
+			if err != nil {
+				return err
+			}
+			if encoded == nil {
+				continue
+			}
+			encodedGroup = append(encodedGroup, encoded)
+		}
+		if len(b.spans) > 0 && len(encodedGroup) > 1 {
+			groupSize, err := m.groupSize(encodedGroup)
+			if err != nil {
+				return err
+			}
+			if b.size+groupSize+1 > m.batchByteLimit {
+				m.exportBatch(ctx, b)
+			}
+		}
+		for _, encoded := range encodedGroup {
+			if err := m.addToBatch(ctx, b, encoded); err != nil {
+				return err
+			}
+		}
+	}
+	m.exportBatch(ctx, b)
+	return b.err
+}
+
+// uploadContext returns a context done with ctx, or once Shutdown abandons the uploads.
+func (m *Exporter) uploadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-m.uploads.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel()
+	}
+}
+
+// addToBatch appends an encoded span to b, exporting b first when the span doesn't fit in it.
+func (m *Exporter) addToBatch(ctx context.Context, b *batcher, encoded []byte) error {
+	size, err := b.spanSize(encoded)
+	if err != nil {
+		return err
+	}
+	// one more byte for the opening bracket of the batch
+	if len(b.spans) > 0 && b.size+size+1 > m.batchByteLimit {
+		m.exportBatch(ctx, b)
+		if b.sizer != nil {
+			// the span was measured as part of the previous batch, measure it on its own.
+			size, err = b.spanSize(encoded)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	b.spans = append(b.spans, encoded)
+	b.size += size
+	return nil
+}
+
+// groupSize returns the number of bytes a group of encoded spans adds to a batch.
+// With a compressed limit or an OTLP format the group is measured on its own, an upper bound of
+// its size in a batch.
+func (m *Exporter) groupSize(encodedGroup [][]byte) (int, error) {
+	b, err := m.newBatcher()
+	if err != nil {
+		return 0, err
+	}
+	defer m.releaseBatcher(b)
+	size := 0
+	for _, encoded := range encodedGroup {
+		n, err := b.spanSize(encoded)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// exportHeld exports the held traces expired at now.
+func (m *Exporter) exportHeld(ctx context.Context, now time.Time) error {
+	if !m.startExport() {
+		// Shutdown exports the held traces
+		return nil
+	}
+	defer m.exports.Done()
+	return m.exportGroups(ctx, m.assembler.expired(now))
+}
+
+// releaseHeldTraces periodically exports the held traces that timed out.
+func (m *Exporter) releaseHeldTraces(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), backgroundExportTimeout)
+			if err := m.exportHeld(ctx, now); err != nil {
+				m.errorHandler.Handle(err)
+			}
+			cancel()
+		}
+	}
+}
+
+// batcher accumulates the encoded spans of the next batch.
+type batcher struct {
+	spans [][]byte
+	size  int
+	sizer *compressedSizer
+	// otlp measures the uncompressed OTLP batches, the spans of a Metis JSON batch are only
+	// separated by commas.
+	otlp *otlpBatchSizer
+	// err is the first error of the exported batches.
+	err error
+}
+
+// spanSize returns the number of bytes an encoded span adds to the batch.
+func (b *batcher) spanSize(encoded []byte) (int, error) {
+	if b.sizer != nil {
+		return b.sizer.add(encoded)
+	}
+	if b.otlp != nil {
+		return b.otlp.add(encoded)
+	}
+	// account for the separating comma
+	return len(encoded) + 1, nil
+}
+
+func (b *batcher) reset() {
+	b.spans = nil
+	b.size = 0
+	if b.sizer != nil {
+		b.sizer.reset()
+	}
+	if b.otlp != nil {
+		b.otlp.reset()
+	}
+}
+
+func (m *Exporter) newBatcher() (*batcher, error) {
+	sizer, err := m.getSizer()
+	if err != nil {
+		return nil, err
+	}
+	if sizer != nil {
+		return &batcher{sizer: sizer}, nil
+	}
+	return &batcher{otlp: m.format.newBatchSizer()}, nil
+}
+
+// getSizer returns a compressed sizer with a compressed limit, nil otherwise.
+func (m *Exporter) getSizer() (*compressedSizer, error) {
+	if !m.compressedLimit {
+		return nil, nil
+	}
+	if sizer, ok := m.sizers.Get().(*compressedSizer); ok {
+		return sizer, nil
+	}
+	return newCompressedSizer(m.compression)
+}
+
+func (m *Exporter) releaseBatcher(b *batcher) {
+	if b.sizer != nil {
+		b.sizer.reset()
+		m.sizers.Put(b.sizer)
+	}
+}
+
+// exportBatch queues the spans of b, or sends them without a queue, and resets b.
+func (m *Exporter) exportBatch(ctx context.Context, b *batcher) {
+	if len(b.spans) == 0 {
+		return
+	}
+	defer b.reset()
+	if m.queue != nil {
+		m.stats.addQueueFull(m.queue.push(ctx, b.spans))
+		return
+	}
+	if err := m.upload(ctx, b.spans); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+// uploadQueued uploads the queued batches until the queue is closed and empty.
+func (m *Exporter) uploadQueued() {
+	defer m.workers.Done()
+	for {
+		b, ok := m.queue.pop()
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(m.uploads, backgroundExportTimeout)
+		if err := m.upload(ctx, b.spans); err != nil {
+			m.errorHandler.Handle(err)
+		}
+		cancel()
+		m.queue.done()
+	}
+}
+
+// upload sends a batch of encoded spans. Failed batches are dropped after retries unless
+// they are spooled, like the batches uploaded while the api key is paused or unavailable.
+func (m *Exporter) upload(ctx context.Context, spans [][]byte) error {
+	paused, keyErr := m.ms.apiKey.paused(ctx)
+	if (keyErr != nil || paused) && m.spool == nil {
+		// a rejected key was already reported
+		m.stats.addExportFailed(len(spans))
+		return keyErr
+	}
+	req, err := m.newExportRequest(spans)
+	if err != nil {
+		return err
+	}
+	if keyErr != nil || paused {
+		// the spool is replayed once the key is valid
+		if spoolErr := m.spool.write(req); spoolErr != nil {
+			m.stats.addExportFailed(len(spans))
+			if keyErr == nil {
+				return fmt.Errorf("metis: exporting paused, spooling failed: %w", spoolErr)
+			}
+			return fmt.Errorf("%w, spooling failed: %v", keyErr, spoolErr)
+		}
+		return keyErr
+	}
+	start := time.Now()
+	err = m.retry.do(ctx, func(ctx context.Context) error {
+		return m.ms.Export(ctx, req)
+	})
+	m.stats.recordUpload(req, time.Since(start), err)
+	if err == nil {
+		m.unauthorizedReported.Store(false)
+		if m.spool != nil {
+			m.spool.wake()
+		}
+		return nil
+	}
+	// an upload out of time is spooled or abandoned
+	abandoned := ctx.Err() != nil
+	if m.spool != nil && (isRetryable(err) || abandoned) {
+		spoolErr := m.spool.write(req)
+		if spoolErr == nil {
+			m.errorHandler.Handle(fmt.Errorf("metis: batch spooled to disk: %w", err))
+			return nil
+		}
+		err = fmt.Errorf("%w, spooling failed: %v", err, spoolErr)
+	}
+	if abandoned {
+		m.stats.addAbandoned(len(spans))
+		return err
+	}
+	m.stats.addExportFailed(len(spans))
+	if errors.Is(err, ErrUnauthorized) {
+		// the same key will keep failing, pause until it changes and report it once.
+		if m.unauthorizedReported.Swap(true) {
+			return nil
+		}
+		return fmt.Errorf("%w, exporting paused until the api key changes: %v", ErrUnauthorized, err)
+	}
+	return err
+}
+
+// newExportRequest encodes and compresses a batch of encoded spans.
+func (m *Exporter) newExportRequest(spans [][]byte) (*exportRequest, error) {
+	body, err := m.format.encodeBatch(spans)
+	if err != nil {
+		return nil, err
+	}
+	body, err = m.compression.compress(body)
+	if err != nil {
+		return nil, err
+	}
+	return &exportRequest{
+		Body:            body,
+		ContentType:     m.format.contentType(),
+		ContentEncoding: m.compression.contentEncoding(),
+		IdempotencyKey:  newIdempotencyKey(),
+		SchemaVersion:   m.format.schemaVersion(),
+		Spans:           len(spans),
+	}, nil
+}
+
+// Stats returns a snapshot of the exporter counters, for example for health checks.
+func (m *Exporter) Stats() Stats {
+	stats := m.stats.snapshot()
+	if m.queue != nil {
+		stats.QueuedSpans = m.queue.len()
+	}
+	return stats
+}
+
+// spanCount returns the number of spans in groups.
+func spanCount(groups [][]trace.ReadOnlySpan) int {
+	n := 0
+	for _, group := range groups {
+		n += len(group)
+	}
+	return n
+}
+
+// ForceFlush exports the held traces, complete or not, and waits for the queued batches to be
+// uploaded, or for ctx to be done.
+func (m *Exporter) ForceFlush(ctx context.Context) error {
+	if !m.startExport() {
+		return nil
+	}
+	var err error
+	if m.assembler != nil {
+		err = m.exportGroups(ctx, m.assembler.releaseAll())
+	}
+	m.exports.Done()
+	if err != nil {
+		return err
+	}
+	if m.queue != nil {
+		return m.queue.waitIdle(ctx)
+	}
+	return nil
+}
+
+// Shutdown waits for the exports in flight to finish, exports the held traces, uploads the
+// queued batches and stops replaying the spool. Later exports return an error, their spans are
+// counted in the dropped Stats. Once ctx is done, the uploads in flight and the queued batches are
+// abandoned and the returned error tells how many spans were abandoned.
+func (m *Exporter) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopped = true
+	m.mu.Unlock()
+	abandonedBefore := m.stats.droppedAbandoned.Load()
+	if !waitContext(ctx, &m.exports) {
+		m.cancelUploads()
+		m.exports.Wait()
+	}
+	var err error
+	if m.assembler != nil {
+		close(m.done)
+		err = m.exportGroups(ctx, m.assembler.releaseAll())
+	}
+	if m.queue != nil {
+		m.queue.close()
+		if !waitContext(ctx, &m.workers) {
+			m.stats.addAbandoned(m.queue.discard())
+			m.cancelUploads()
+			m.workers.Wait()
+		}
+	}
+	m.cancelUploads()
+	if m.spool != nil {
+		m.spool.close()
+	}
+	if abandoned := m.stats.droppedAbandoned.Load() - abandonedBefore; abandoned > 0 {
+		return fmt.Errorf("metis: shutdown abandoned %d spans: %w", abandoned, ctx.Err())
+	}
+	return err
+}
+
+// waitContext waits for wg, or for ctx to be done. It returns false when ctx is done first.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,171 @@
+package metis
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// SpanFilter decides which spans are exported to Metis.
+type SpanFilter interface {
+	// Keep reports whether span should be exported.
+	Keep(span trace.ReadOnlySpan) bool
+}
+
+// SpanFilterFunc is an adapter to allow the use of ordinary functions as SpanFilter.
+type SpanFilterFunc func(span trace.ReadOnlySpan) bool
+
+// Keep returns f(span).
+func (f SpanFilterFunc) Keep(span trace.ReadOnlySpan) bool {
+	return f(span)
+}
+
+// startObserver is implemented by filters that need to see spans when they start.
+// It is called by the span processors of this package, and by the exporter for the spans of
+// an export before filtering them.
+type startObserver interface {
+	OnStart(span trace.ReadOnlySpan)
+}
+
+// HTTPServerSpans keeps spans with an http.route attribute and server spans with an http.method attribute.
+func HTTPServerSpans() SpanFilter {
+	return SpanFilterFunc(isHTTPServerSpan)
+}
+
+func isHTTPServerSpan(span trace.ReadOnlySpan) bool {
+	isServer := span.SpanKind() == oteltrace.SpanKindServer
+	for _, attr := range span.Attributes() {
+		if attr.Key == semconv.HTTPRouteKey || (isServer && attr.Key == semconv.HTTPMethodKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// DBSpans keeps spans with a db.system attribute.
+func DBSpans() SpanFilter {
+	return SpanFilterFunc(func(span trace.ReadOnlySpan) bool {
+		return hasAttribute(span, semconv.DBSystemKey)
+	})
+}
+
+// InstrumentationScopes keeps spans created by the tracers with the given names.
+func InstrumentationScopes(names ...string) SpanFilter {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return SpanFilterFunc(func(span trace.ReadOnlySpan) bool {
+		_, ok := set[span.InstrumentationScope().Name]
+		return ok
+	})
+}
+
+// AnyOf keeps spans kept by at least one of filters.
+func AnyOf(filters ...SpanFilter) SpanFilter {
+	return compositeFilter{filters: filters, any: true}
+}
+
+// AllOf keeps spans kept by all of filters.
+func AllOf(filters ...SpanFilter) SpanFilter {
+	return compositeFilter{filters: filters}
+}
+
+type compositeFilter struct {
+	filters []SpanFilter
+	any     bool
+}
+
+func (c compositeFilter) Keep(span trace.ReadOnlySpan) bool {
+	for _, f := range c.filters {
+		if f.Keep(span) == c.any {
+			return c.any
+		}
+	}
+	return !c.any
+}
+
+func (c compositeFilter) OnStart(span trace.ReadOnlySpan) {
+	for _, f := range c.filters {
+		if o, ok := f.(startObserver); ok {
+			o.OnStart(span)
+		}
+	}
+}
+
+func hasAttribute(span trace.ReadOnlySpan, key attribute.Key) bool {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTrackedTraces bounds the number of traces remembered by the default filter.
+const maxTrackedTraces = 10000
+
+// defaultFilter keeps HTTP server spans, and DB spans of the traces of kept HTTP server spans.
+// Traces are learned when their HTTP server span starts, which requires one of the span
+// processors of this package, and when it is exported. Without them, DB spans exported before
+// the HTTP server span of their trace are dropped.
+type defaultFilter struct {
+	traces *traceSet
+}
+
+func newDefaultFilter() *defaultFilter {
+	return &defaultFilter{traces: newTraceSet(maxTrackedTraces)}
+}
+
+func (d *defaultFilter) OnStart(span trace.ReadOnlySpan) {
+	if isHTTPServerSpan(span) {
+		d.traces.add(span.SpanContext().TraceID())
+	}
+}
+
+func (d *defaultFilter) Keep(span trace.ReadOnlySpan) bool {
+	traceID := span.SpanContext().TraceID()
+	if isHTTPServerSpan(span) {
+		d.traces.add(traceID)
+		return true
+	}
+	return hasAttribute(span, semconv.DBSystemKey) && d.traces.contains(traceID)
+}
+
+// traceSet is a set of trace IDs bounded in size, the oldest trace is forgotten when full.
+// It is safe for concurrent use.
+type traceSet struct {
+	mu     sync.Mutex
+	size   int
+	traces map[oteltrace.TraceID]struct{}
+	// order holds the traces oldest first.
+	order []oteltrace.TraceID
+}
+
+func newTraceSet(size int) *traceSet {
+	return &traceSet{size: size, traces: map[oteltrace.TraceID]struct{}{}}
+}
+
+func (t *traceSet) add(traceID oteltrace.TraceID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.traces[traceID]; ok {
+		return
+	}
+	if len(t.order) == t.size {
+		delete(t.traces, t.order[0])
+		t.order = t.order[1:]
+	}
+	t.traces[traceID] = struct{}{}
+	t.order = append(t.order, traceID)
+}
+
+func (t *traceSet) contains(traceID oteltrace.TraceID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.traces[traceID]
+	return ok
+}
@@ -0,0 +1,128 @@
+package metis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestSpanFilters(t *testing.T) {
+	t.Parallel()
+	spans := tracetest.SpanStubs{
+		{Name: "route", Attributes: []attribute.KeyValue{semconv.HTTPRoute("/")}},
+		{Name: "server", SpanKind: oteltrace.SpanKindServer, Attributes: []attribute.KeyValue{semconv.HTTPMethod("GET")}},
+		{Name: "client", SpanKind: oteltrace.SpanKindClient, Attributes: []attribute.KeyValue{semconv.HTTPMethod("GET")}},
+		{Name: "db", Attributes: []attribute.KeyValue{semconv.DBSystemPostgreSQL}},
+		{Name: "cron", InstrumentationLibrary: instrumentation.Scope{Name: "cron"}},
+	}.Snapshots()
+	tests := []struct {
+		name   string
+		filter SpanFilter
+		want   []string
+	}{
+		{"http", HTTPServerSpans(), []string{"route", "server"}},
+		{"db", DBSpans(), []string{"db"}},
+		{"scopes", InstrumentationScopes("cron", "balagan"), []string{"cron"}},
+		{"any of", AnyOf(DBSpans(), InstrumentationScopes("cron")), []string{"db", "cron"}},
+		{"all of", AllOf(HTTPServerSpans(), SpanFilterFunc(func(s trace.ReadOnlySpan) bool {
+			return s.SpanKind() == oteltrace.SpanKindServer
+		})), []string{"server"}},
+		{"empty any of", AnyOf(), nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, span := range spans {
+			if tt.filter.Keep(span) {
+				got = append(got, span.Name())
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultFilterKeepsDBSpansOfHTTPTraces(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+	)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	tracer := tp.Tracer("balagan")
+
+	// an http request running a query, the route is only known once the handler runs
+	ctx, server := tracer.Start(context.Background(), "GET",
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(semconv.HTTPMethod("GET")))
+	_, query := tracer.Start(ctx, "SELECT users", oteltrace.WithAttributes(semconv.DBSystemPostgreSQL))
+	query.End()
+	server.SetAttributes(semconv.HTTPRoute("/users"))
+	server.End()
+
+	// a query of a background job
+	_, job := tracer.Start(context.Background(), "SELECT jobs", oteltrace.WithAttributes(semconv.DBSystemPostgreSQL))
+	job.End()
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+	if len(mm.spans) != 1 {
+		t.Fatalf("expected 1 export request, got %d", len(mm.spans))
+	}
+	for _, name := range []string{`"GET"`, `"SELECT users"`} {
+		if !strings.Contains(mm.spans[0], name) {
+			t.Errorf("expected %s to be exported", name)
+		}
+	}
+	if strings.Contains(mm.spans[0], "SELECT jobs") {
+		t.Errorf("expected the background query to be filtered out")
+	}
+}
+
+func TestDefaultFilterWithoutSpanProcessor(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	tp := trace.NewTracerProvider(trace.WithBatcher(exp))
+	tracer := tp.Tracer("balagan")
+
+	// the query ends first, the server span of its trace is exported in the same batch
+	ctx, server := tracer.Start(context.Background(), "GET",
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(semconv.HTTPMethod("GET")))
+	_, query := tracer.Start(ctx, "SELECT users", oteltrace.WithAttributes(semconv.DBSystemPostgreSQL))
+	query.End()
+	server.End()
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+	if len(mm.spans) != 1 || !strings.Contains(mm.spans[0], "SELECT users") {
+		t.Errorf("expected the query of the http trace to be exported, got %v", mm.spans)
+	}
+}
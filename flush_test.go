@@ -0,0 +1,86 @@
+package metis
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// startChildSpan ends a child span whose root is still running, trace assembly holds it.
+func startChildSpan(tp *trace.TracerProvider) {
+	ctx, _ := tp.Tracer("balagan").Start(context.Background(), "root")
+	_, child := tp.Tracer("balagan").Start(ctx, "child")
+	child.End()
+}
+
+func TestForceFlushDrainsExporter(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithTraceAssembly(TraceAssemblyConfig{Timeout: time.Hour}),
+		WithExportQueue(ExportQueueConfig{}),
+	)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	defer tp.Shutdown(context.Background())
+	startChildSpan(tp)
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+	// the upload is over once ForceFlush returns
+	if got := mm.requests(); len(got) != 1 {
+		t.Errorf("expected the held span to be exported, got %d requests", len(got))
+	}
+}
+
+func TestForceFlushDeadline(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithExportQueue(ExportQueueConfig{Workers: 1}))
+	_, release := mm.block()
+	defer close(release)
+	tp := trace.NewTracerProvider(trace.WithSpanProcessor(&spanProcessor{
+		SpanProcessor: trace.NewBatchSpanProcessor(exp),
+		exporter:      exp,
+	}))
+	_, span := tp.Tracer("balagan").Start(context.Background(), "span", oteltrace.WithSpanKind(oteltrace.SpanKindServer))
+	span.End()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tp.ForceFlush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ForceFlush to stop at the deadline, got %v", err)
+	}
+}
+
+func TestMaxExportDelay(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithTraceAssembly(TraceAssemblyConfig{Timeout: time.Hour}),
+		WithMaxExportDelay(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	defer tp.Shutdown(context.Background())
+	startChildSpan(tp)
+	if got := waitForRequests(mm, 1); len(got) != 1 {
+		t.Errorf("expected the span to be sent within the max export delay, got %d requests", len(got))
+	}
+}
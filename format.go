@@ -0,0 +1,75 @@
+package metis
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ExportFormat is the payload format of export requests.
+type ExportFormat int
+
+const (
+	// MetisJSON is the Metis JSON array of spans laid out like the stdouttrace exporter output.
+	MetisJSON ExportFormat = iota
+	// OTLPProtobuf is an OTLP/HTTP protobuf ExportTraceServiceRequest.
+	OTLPProtobuf
+	// OTLPJSON is an OTLP/HTTP JSON ExportTraceServiceRequest.
+	OTLPJSON
+)
+
+// payloadEncoder encodes spans one at a time, batches of encoded spans are joined with
+// ExportFormat.encodeBatch. Encoders are not safe for concurrent use.
+type payloadEncoder interface {
+	// encode returns the encoding of span. The returned slice is owned by the caller.
+	encode(span trace.ReadOnlySpan) ([]byte, error)
+}
+
+func (f ExportFormat) newEncoder() (payloadEncoder, error) {
+	switch f {
+	case MetisJSON:
+		return &spanEncoder{}, nil
+	case OTLPProtobuf:
+		return &otlpEncoder{}, nil
+	case OTLPJSON:
+		return &otlpEncoder{json: true}, nil
+	}
+	return nil, fmt.Errorf("unknown export format: %d", f)
+}
+
+// encodeBatch joins spans encoded by an encoder of f into a request body.
+func (f ExportFormat) encodeBatch(spans [][]byte) ([]byte, error) {
+	switch f {
+	case OTLPProtobuf:
+		return encodeOTLPBatch(spans)
+	case OTLPJSON:
+		return encodeOTLPJSONBatch(spans)
+	}
+	return encodeBatch(spans), nil
+}
+
+// newBatchSizer returns the sizer of the batches of f, nil when a batch is an array of its spans.
+func (f ExportFormat) newBatchSizer() *otlpBatchSizer {
+	switch f {
+	case OTLPProtobuf:
+		return newOTLPBatchSizer(false)
+	case OTLPJSON:
+		return newOTLPBatchSizer(true)
+	}
+	return nil
+}
+
+// schemaVersion returns the version of the payloads of f, OTLP payloads are versioned by OTLP itself.
+func (f ExportFormat) schemaVersion() string {
+	if f == MetisJSON {
+		return PayloadSchemaVersion
+	}
+	return ""
+}
+
+func (f ExportFormat) contentType() string {
+	if f == OTLPProtobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
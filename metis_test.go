@@ -1,52 +1,200 @@
 package metis
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type metisMockServer struct {
-	spans []string
-	t     *testing.T
+	mu         sync.Mutex
+	spans      []string
+	headers    []http.Header
+	t          *testing.T
+	statusCode int
+	body       string
+	// responses are the status codes of the first requests, statusCode is used after them.
+	responses  []int
+	retryAfter string
+	// apiKey is the only api key accepted when set, other requests are unauthorized.
+	apiKey string
+	// received is signaled by each request held until release is closed.
+	received chan struct{}
+	release  chan struct{}
 }
 
 func (m *metisMockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	bodyStr, err := io.ReadAll(r.Body)
+	m.mu.Lock()
+	received, release := m.received, m.release
+	m.mu.Unlock()
+	if release != nil {
+		received <- struct{}{}
+		<-release
+	}
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			m.t.Errorf("gzip.NewReader() error = %v", err)
+			return
+		}
+		body = gr
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			m.t.Errorf("zstd.NewReader() error = %v", err)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	bodyStr, err := io.ReadAll(body)
 	if err != nil {
 		m.t.Errorf("io.ReadAll() error = %v", err)
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.spans = append(m.spans, string(bodyStr))
-	w.WriteHeader(http.StatusOK)
+	m.headers = append(m.headers, r.Header.Clone())
+	statusCode := m.statusCode
+	if len(m.responses) > 0 {
+		statusCode = m.responses[0]
+		m.responses = m.responses[1:]
+	}
+	if m.apiKey != "" && r.Header.Get("x-api-key") != m.apiKey {
+		statusCode = http.StatusUnauthorized
+	}
+	if statusCode == 0 || statusCode == http.StatusOK {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if m.retryAfter != "" {
+		w.Header().Set("Retry-After", m.retryAfter)
+	}
+	w.WriteHeader(statusCode)
+	io.WriteString(w, m.body)
+}
+
+func (m *metisMockServer) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.spans...)
+}
+
+// respond answers the next requests with responses, then with statusCode.
+func (m *metisMockServer) respond(statusCode int, responses ...int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statusCode = statusCode
+	m.responses = responses
+}
+
+// acceptAPIKey makes the server reject the requests of another api key.
+func (m *metisMockServer) acceptAPIKey(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiKey = key
+}
+
+// block holds the next requests until release is closed, each one signals received first.
+func (m *metisMockServer) block() (received <-chan struct{}, release chan<- struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received = make(chan struct{}, 100)
+	m.release = make(chan struct{})
+	return m.received, m.release
+}
+
+// keepAll is a span filter keeping every span.
+var keepAll = SpanFilterFunc(func(trace.ReadOnlySpan) bool { return true })
+
+// newTestOptions starts a mock server and returns the options uploading every span to it,
+// followed by opts.
+func newTestOptions(t *testing.T, opts []Option) ([]Option, *metisMockServer) {
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(mm)
+	t.Cleanup(ts.Close)
+	return append([]Option{
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+	}, opts...), mm
+}
+
+// newTestExporter returns an exporter uploading every span to a mock server, configured by opts.
+// It is shut down at the end of the test.
+func newTestExporter(t *testing.T, opts ...Option) (*Exporter, *metisMockServer) {
+	opts, mm := newTestOptions(t, opts)
+	exp, err := NewExporter(opts...)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	t.Cleanup(func() { _ = exp.Shutdown(context.Background()) })
+	return exp, mm
+}
+
+// newTestTracerProvider returns a tracer provider exporting every span to a mock server,
+// configured by opts. The global propagator it installs is restored at the end of the test.
+func newTestTracerProvider(t *testing.T, opts ...Option) (*trace.TracerProvider, *metisMockServer) {
+	propagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagator) })
+	opts, mm := newTestOptions(t, opts)
+	tp, err := NewTracerProvider(opts...)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	return tp, mm
 }
 
 func TestNewTracerProvider(t *testing.T) {
+	t.Parallel()
 	mm := &metisMockServer{t: t}
 	// setup mock metis server
 	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
 	defer ts.Close()
 
 	// setup telemetry
-	tp, err := NewTracerProviderWithLogin(ts.URL, "test-api-key")
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(InstrumentationScopes("balagan1")),
+	)
 	if err != nil {
-		t.Errorf("NewTracerProvider() error = %v", err)
+		t.Fatalf("NewTracerProvider() error = %v", err)
 	}
-	otel.SetTracerProvider(tp)
 
-	spanTextIdentifierHTTP = "balagan1"
 	// send a traces
-	_, span := otel.Tracer("balagan1").Start(context.Background(), "gadol")
+	_, span := tp.Tracer("balagan1").Start(context.Background(), "gadol")
 	span.End()
-	_, span = otel.Tracer("balagan2").Start(context.Background(), "gadol")
+	_, span = tp.Tracer("balagan2").Start(context.Background(), "gadol")
 	span.End()
-	_, span = otel.Tracer("balagan3").Start(context.Background(), "gadol")
+	_, span = tp.Tracer("balagan3").Start(context.Background(), "gadol")
 	span.End()
 
 	// flush all spans
@@ -62,7 +210,7 @@ func TestNewTracerProvider(t *testing.T) {
 }
 
 func TestTraceProviderBatcherWithByteSizeLimit(t *testing.T) {
-	queueSize = 10000
+	t.Parallel()
 	mm := &metisMockServer{t: t}
 
 	// setup mock metis server
@@ -70,17 +218,28 @@ func TestTraceProviderBatcherWithByteSizeLimit(t *testing.T) {
 	defer ts.Close()
 
 	// setup telemetry
-	tp, err := NewTracerProviderWithLogin(ts.URL, "test-api-key")
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithBatchByteLimit(10000),
+		WithSpanFilter(keepAll),
+		// the split depends on the size of the resource, pin it rather than detect it on each host
+		WithResource(resource.NewSchemaless(
+			semconv.ServiceName("metis-go-client"),
+			semconv.ServiceVersion(""),
+			semconv.TelemetrySDKVersion("1.16.0"),
+			semconv.TelemetrySDKName("opentelemetry"),
+			semconv.TelemetrySDKLanguageGo,
+		)),
+	)
 	if err != nil {
-		t.Errorf("NewTracerProvider() error = %v", err)
+		t.Fatalf("NewTracerProvider() error = %v", err)
 	}
-	otel.SetTracerProvider(tp)
 
-	spanTextIdentifierHTTP = "balagan"
 	// send traces to fill up the batcher
 	for i := 0; i < 10; i++ {
 
-		_, span := otel.Tracer(fmt.Sprintf("balagan-%d", i)).Start(context.Background(), "Run")
+		_, span := tp.Tracer(fmt.Sprintf("balagan-%d", i)).Start(context.Background(), "Run")
 		span.End()
 	}
 
@@ -92,11 +251,11 @@ func TestTraceProviderBatcherWithByteSizeLimit(t *testing.T) {
 	if len(mm.spans) != 2 {
 		t.Fatalf("expected 2 span chanks, got %d", len(mm.spans))
 	}
-	if len(mm.spans[0]) < 9690 || len(mm.spans[0]) > 9710 {
-		t.Errorf("expected 9700 span, got %d", len(mm.spans[0]))
+	if len(mm.spans[0]) > 10000 {
+		t.Errorf("expected first chunk within 10000 bytes, got %d", len(mm.spans[0]))
 	}
-	if len(mm.spans[1]) != 1079 {
-		t.Errorf("expected 1079 span, got %d", len(mm.spans[1]))
+	if len(mm.spans[0])+len(mm.spans[1]) <= 10000 {
+		t.Errorf("expected chunks to exceed 10000 bytes together, got %d and %d", len(mm.spans[0]), len(mm.spans[1]))
 	}
 	// check all spans where processed
 	for i := 0; i < 9; i++ {
@@ -123,3 +282,860 @@ func validateJSON(s string) error {
 	}
 	return nil
 }
+
+func TestErrorHandler(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close() // export to a closed server
+
+	var handled []error
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(ErrorHandlerFunc(func(err error) {
+			handled = append(handled, err)
+		})),
+	)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	_, span := tp.Tracer("balagan").Start(context.Background(), "gadol")
+	span.End()
+	_ = tp.Shutdown(context.Background())
+	if len(handled) == 0 {
+		t.Errorf("expected error handler to be called")
+	}
+}
+
+func TestExportErrorsReportedOnce(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close() // export to a closed server
+
+	var handled []error
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(ErrorHandlerFunc(func(err error) {
+			handled = append(handled, err)
+		})),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	defer func() { _ = exp.Shutdown(context.Background()) }()
+
+	// the exporter returns the error to the processor
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err == nil {
+		t.Error("expected ExportSpans to return the error")
+	}
+	if len(handled) != 0 {
+		t.Errorf("expected the returned error not to be handled, got %v", handled)
+	}
+	// the batch span processor of NewTracerProvider gets nil, the error is handled instead
+	if err := (reportingExporter{exp}).ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Errorf("expected the handled error not to be returned, got %v", err)
+	}
+	if len(handled) != 1 {
+		t.Errorf("expected the error to be handled once, got %v", handled)
+	}
+}
+
+func TestMetisServerExportStatusCodes(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		statusCode   int
+		wantErr      bool
+		unauthorized bool
+	}{
+		{statusCode: http.StatusOK},
+		{statusCode: http.StatusAccepted},
+		{statusCode: http.StatusUnauthorized, wantErr: true, unauthorized: true},
+		{statusCode: http.StatusForbidden, wantErr: true, unauthorized: true},
+		{statusCode: http.StatusRequestEntityTooLarge, wantErr: true},
+		{statusCode: http.StatusTooManyRequests, wantErr: true},
+		{statusCode: http.StatusInternalServerError, wantErr: true},
+		{statusCode: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tt := range tests {
+		mm := &metisMockServer{t: t, statusCode: tt.statusCode, body: "balagan"}
+		ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+		ms := &metisServer{url: ts.URL, apiKey: newAPIKeyResolver(StaticAPIKey("test-api-key")), client: ts.Client()}
+
+		err := ms.Export(context.Background(), &exportRequest{Body: []byte("[]"), ContentType: "application/json"})
+		ts.Close()
+		if !tt.wantErr {
+			if err != nil {
+				t.Errorf("status %d: Export() error = %v", tt.statusCode, err)
+			}
+			continue
+		}
+		var exportErr *ExportError
+		if !errors.As(err, &exportErr) {
+			t.Fatalf("status %d: expected ExportError, got %v", tt.statusCode, err)
+		}
+		if exportErr.StatusCode != tt.statusCode {
+			t.Errorf("expected status %d, got %d", tt.statusCode, exportErr.StatusCode)
+		}
+		if exportErr.Body != "balagan" {
+			t.Errorf("expected body balagan, got %q", exportErr.Body)
+		}
+		if errors.Is(err, ErrUnauthorized) != tt.unauthorized {
+			t.Errorf("status %d: errors.Is(err, ErrUnauthorized) = %v", tt.statusCode, !tt.unauthorized)
+		}
+	}
+}
+
+func TestExporterStopsOnUnauthorized(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, statusCode: http.StatusUnauthorized}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	var handled []error
+	tp, err := NewTracerProvider(
+		WithExporterURL(ts.URL),
+		WithAPIKey("bad-api-key"),
+		WithSpanFilter(keepAll),
+		WithErrorHandler(ErrorHandlerFunc(func(err error) {
+			handled = append(handled, err)
+		})),
+	)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, span := tp.Tracer("balagan").Start(context.Background(), "gadol")
+		span.End()
+		_ = tp.ForceFlush(context.Background())
+	}
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+	if len(mm.spans) != 1 {
+		t.Errorf("expected 1 export request, got %d", len(mm.spans))
+	}
+	if len(handled) != 1 || !errors.Is(handled[0], ErrUnauthorized) {
+		t.Errorf("expected a single ErrUnauthorized, got %v", handled)
+	}
+}
+
+// newTestSpans returns n ended spans with an http.route attribute.
+func newTestSpans(n int) []trace.ReadOnlySpan {
+	stubs := make(tracetest.SpanStubs, n)
+	for i := range stubs {
+		stubs[i] = tracetest.SpanStub{
+			Name:       fmt.Sprintf("GET /balagan/%d", i),
+			SpanKind:   oteltrace.SpanKindServer,
+			Attributes: []attribute.KeyValue{semconv.HTTPRoute("/balagan")},
+		}
+	}
+	return stubs.Snapshots()
+}
+
+func TestExporterRetry(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, responses: []int{
+		http.StatusServiceUnavailable,
+		http.StatusBadGateway,
+		http.StatusTooManyRequests,
+	}}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithRetry(RetryConfig{Enabled: true, InitialInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	if err := exp.ExportSpans(context.Background(), newTestSpans(2)); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if len(mm.headers) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(mm.headers))
+	}
+	key := mm.headers[0].Get(idempotencyKeyHeader)
+	if key == "" {
+		t.Fatalf("expected %s header", idempotencyKeyHeader)
+	}
+	for _, h := range mm.headers {
+		if h.Get(idempotencyKeyHeader) != key {
+			t.Errorf("expected idempotency key %s across retries, got %s", key, h.Get(idempotencyKeyHeader))
+		}
+	}
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if mm.headers[4].Get(idempotencyKeyHeader) == key {
+		t.Errorf("expected a new idempotency key for a new batch")
+	}
+}
+
+func TestExporterRetryNotRetryable(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, statusCode: http.StatusRequestEntityTooLarge}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithErrorHandler(NopErrorHandler()),
+		WithRetry(RetryConfig{Enabled: true, InitialInterval: time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err == nil {
+		t.Fatalf("ExportSpans() expected error")
+	}
+	if len(mm.spans) != 1 {
+		t.Errorf("expected 1 request, got %d", len(mm.spans))
+	}
+}
+
+func TestExporterRetryAfter(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, responses: []int{http.StatusTooManyRequests}, retryAfter: "1"}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithRetry(RetryConfig{Enabled: true, InitialInterval: time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	start := time.Now()
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected retry to wait for Retry-After, waited %v", elapsed)
+	}
+}
+
+func TestExporterRetryStopsAtDeadline(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, statusCode: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithErrorHandler(NopErrorHandler()),
+		WithRetry(RetryConfig{Enabled: true, InitialInterval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = exp.ExportSpans(ctx, newTestSpans(1))
+	var exportErr *ExportError
+	if !errors.As(err, &exportErr) || exportErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 ExportError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected retries to stop at the deadline, took %v", elapsed)
+	}
+	if got := len(mm.requests()); got < 2 {
+		t.Errorf("expected retries, got %d requests", got)
+	}
+}
+
+func TestExporterRetryDefaults(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, statusCode: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithRetry(RetryConfig{Enabled: true}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	if exp.retry != DefaultRetryConfig {
+		t.Errorf("expected the zero fields to default to DefaultRetryConfig, got %+v", exp.retry)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := exp.ExportSpans(ctx, newTestSpans(1)); err == nil {
+		t.Fatal("ExportSpans() expected error")
+	}
+	// the first retry waits about DefaultRetryConfig.InitialInterval, past the deadline
+	if len(mm.spans) != 1 {
+		t.Errorf("expected the default intervals to apply, got %d requests", len(mm.spans))
+	}
+}
+
+// newHungServer returns a server answering no request until the test ends.
+func newHungServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		ts.Close()
+	})
+	return ts
+}
+
+func TestExporterExportHonorsContext(t *testing.T) {
+	t.Parallel()
+	ts := newHungServer(t)
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithErrorHandler(NopErrorHandler()),
+		WithRetry(RetryConfig{}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := exp.ExportSpans(ctx, newTestSpans(1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the upload to stop at the deadline, took %v", elapsed)
+	}
+	if got := exp.Stats().Dropped; got.Abandoned != 1 || got.ExportFailed != 0 {
+		t.Errorf("expected 1 abandoned span, got %+v", got)
+	}
+}
+
+func TestExporterResponseTimeout(t *testing.T) {
+	t.Parallel()
+	ts := newHungServer(t)
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithErrorHandler(NopErrorHandler()),
+		WithRetry(RetryConfig{}),
+		WithResponseTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	start := time.Now()
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected a response timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the response timeout to apply, took %v", elapsed)
+	}
+	if got := exp.Stats().Dropped.ExportFailed; got != 1 {
+		t.Errorf("expected 1 failed span, got %d", got)
+	}
+}
+
+func TestExporterCompression(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		compression Compression
+		encoding    string
+	}{
+		{GzipCompression, "gzip"},
+		{ZstdCompression, "zstd"},
+	} {
+		mm := &metisMockServer{t: t}
+		ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+
+		exp, err := NewExporter(
+			WithExporterURL(ts.URL),
+			WithAPIKey("test-api-key"),
+			WithCompression(tt.compression),
+		)
+		if err != nil {
+			t.Fatalf("NewExporter() error = %v", err)
+		}
+		if err := exp.ExportSpans(context.Background(), newTestSpans(3)); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+		ts.Close()
+		if len(mm.spans) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(mm.spans))
+		}
+		if got := mm.headers[0].Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("expected Content-Encoding %s, got %s", tt.encoding, got)
+		}
+		if err := validateJSON(mm.spans[0]); err != nil {
+			t.Errorf("validateJSON() error = %v", err)
+		}
+		if !strings.Contains(mm.spans[0], "GET /balagan/2") {
+			t.Errorf("expected decompressed body to contain the spans")
+		}
+	}
+}
+
+func TestExporterCompressedBatchLimit(t *testing.T) {
+	t.Parallel()
+	export := func(opts ...Option) int {
+		mm := &metisMockServer{t: t}
+		ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+		defer ts.Close()
+		exp, err := NewExporter(append([]Option{
+			WithExporterURL(ts.URL),
+			WithAPIKey("test-api-key"),
+			WithBatchByteLimit(5000),
+			WithCompression(GzipCompression),
+		}, opts...)...)
+		if err != nil {
+			t.Fatalf("NewExporter() error = %v", err)
+		}
+		if err := exp.ExportSpans(context.Background(), newTestSpans(20)); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+		for _, span := range mm.spans {
+			if err := validateJSON(span); err != nil {
+				t.Errorf("validateJSON() error = %v", err)
+			}
+		}
+		return len(mm.spans)
+	}
+	uncompressedLimit := export()
+	compressedLimit := export(WithCompressedBatchLimit())
+	if compressedLimit >= uncompressedLimit {
+		t.Errorf("expected fewer requests with a compressed limit, got %d and %d", compressedLimit, uncompressedLimit)
+	}
+}
+
+func TestExporterConcurrentExportSpans(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithBatchByteLimit(3000),
+		WithCompression(GzipCompression),
+		WithCompressedBatchLimit(),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := exp.ExportSpans(context.Background(), newTestSpans(10)); err != nil {
+				t.Errorf("ExportSpans() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	exported := 0
+	for _, body := range mm.spans {
+		var js []map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &js); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		exported += len(js)
+	}
+	if exported != 100 {
+		t.Errorf("expected 100 exported spans, got %d", exported)
+	}
+}
+
+func TestExporterConcurrentShutdown(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	tp := trace.NewTracerProvider(trace.WithSpanProcessor(trace.NewSimpleSpanProcessor(exp)))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, span := tp.Tracer("balagan").Start(context.Background(), fmt.Sprintf("GET /%d/%d", i, j),
+					oteltrace.WithAttributes(semconv.HTTPRoute("/balagan")))
+				span.End()
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := exp.Shutdown(context.Background()); err != nil {
+			t.Errorf("Shutdown() error = %v", err)
+		}
+	}()
+	wg.Wait()
+
+	// exports after shutdown fail and count their spans as dropped
+	mm.mu.Lock()
+	requests := len(mm.spans)
+	mm.mu.Unlock()
+	dropped := exp.Stats().Dropped.AfterShutdown
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); !errors.Is(err, errExporterStopped) {
+		t.Errorf("expected errExporterStopped, got %v", err)
+	}
+	if got := exp.Stats().Dropped.AfterShutdown; got != dropped+1 {
+		t.Errorf("expected the span to be counted as dropped after shutdown, got %d", got-dropped)
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if len(mm.spans) != requests {
+		t.Errorf("expected no export after shutdown")
+	}
+}
+
+func TestExporterStoppedDuringExport(t *testing.T) {
+	t.Parallel()
+	received := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request context is canceled with the connection once the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithRetry(RetryConfig{}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	exported := make(chan struct{})
+	go func() {
+		defer close(exported)
+		_ = exp.ExportSpans(ctx, newTestSpans(1))
+	}()
+	<-received
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		_ = exp.Shutdown(context.Background())
+	}()
+	for stopped := false; !stopped; time.Sleep(time.Millisecond) {
+		exp.mu.RLock()
+		stopped = exp.stopped
+		exp.mu.RUnlock()
+	}
+
+	// the upload in flight doesn't hold up the exports following Shutdown
+	start := time.Now()
+	if err := exp.ExportSpans(context.Background(), newTestSpans(2)); !errors.Is(err, errExporterStopped) {
+		t.Errorf("expected errExporterStopped, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the export to fail at once, took %v", elapsed)
+	}
+	if got := exp.Stats().Dropped.AfterShutdown; got != 2 {
+		t.Errorf("expected 2 spans dropped after shutdown, got %d", got)
+	}
+	cancel()
+	<-exported
+	<-shutdown
+}
+
+func TestExporterShutdownAbandonsUploads(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t)
+	received, release := mm.block()
+	defer close(release)
+	exported := make(chan error, 1)
+	go func() {
+		exported <- exp.ExportSpans(context.Background(), newTestSpans(2))
+	}()
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := exp.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "abandoned 2 spans") {
+		t.Errorf("expected 2 abandoned spans, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Shutdown to return at its deadline, took %v", elapsed)
+	}
+	if err := <-exported; err == nil {
+		t.Error("expected the abandoned upload to fail")
+	}
+	if got := exp.Stats().Dropped.Abandoned; got != 2 {
+		t.Errorf("expected 2 abandoned spans, got %d", got)
+	}
+}
+
+func TestTracerProviderShutdownAbandonsUploads(t *testing.T) {
+	t.Parallel()
+	tp, mm := newTestTracerProvider(t)
+	_, release := mm.block()
+	defer close(release)
+	endSpans(tp, 1)
+
+	// the batch span processor uploads the span while shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := tp.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "abandoned 1 spans") {
+		t.Errorf("expected 1 abandoned span, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Shutdown to return at its deadline, took %v", elapsed)
+	}
+}
+
+func TestNewSpanProcessor(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+
+	sp, err := NewSpanProcessor(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+	)
+	if err != nil {
+		t.Fatalf("NewSpanProcessor() error = %v", err)
+	}
+	other := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(
+		trace.WithSpanProcessor(sp),
+		trace.WithSyncer(other),
+	)
+	tracer := tp.Tracer("balagan")
+	_, span := tracer.Start(context.Background(), "GET /balagan", oteltrace.WithAttributes(semconv.HTTPRoute("/balagan")))
+	span.End()
+	_, span = tracer.Start(context.Background(), "irrelevant")
+	span.End()
+	// the in memory exporter forgets its spans on shutdown
+	if len(other.GetSpans()) != 2 {
+		t.Errorf("expected the other processor to get 2 spans, got %d", len(other.GetSpans()))
+	}
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+	if len(mm.spans) != 1 {
+		t.Fatalf("expected 1 export request, got %d", len(mm.spans))
+	}
+	if !strings.Contains(mm.spans[0], "GET /balagan") || strings.Contains(mm.spans[0], "irrelevant") {
+		t.Errorf("expected only the relevant span to be exported, got %s", mm.spans[0])
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// goldenPayloadFile holds the Metis JSON payload of newGoldenSpans for PayloadSchemaVersion.
+var goldenPayloadFile = filepath.Join("testdata", "payload_v"+PayloadSchemaVersion+".golden.json")
+
+// newGoldenSpans returns spans using every field of the payload.
+func newGoldenSpans() []trace.ReadOnlySpan {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	traceState, _ := oteltrace.ParseTraceState("metis=balagan")
+	parent := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     oteltrace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: oteltrace.FlagsSampled,
+		TraceState: traceState,
+		Remote:     true,
+	})
+	server := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    parent.TraceID(),
+		SpanID:     oteltrace.SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		TraceFlags: oteltrace.FlagsSampled,
+	})
+	res := resource.NewSchemaless(semconv.ServiceName("balagan"), semconv.ServiceVersion("v1.2.3"))
+	return tracetest.SpanStubs{
+		{
+			Name:        "GET /users/{id}",
+			SpanContext: server,
+			Parent:      parent,
+			SpanKind:    oteltrace.SpanKindServer,
+			StartTime:   start,
+			EndTime:     start.Add(1500 * time.Millisecond),
+			Attributes: []attribute.KeyValue{
+				semconv.HTTPRoute("/users/{id}"),
+				attribute.Int("http.status_code", 500),
+				attribute.Bool("metis.sampled", true),
+				attribute.Float64("metis.ratio", 0.000001),
+				attribute.String("http.user_agent", `Go-http-client/1.1 "<&>" é`),
+				attribute.StringSlice("http.request.header.accept", []string{"text/html", "*/*"}),
+				attribute.Int64Slice("metis.sizes", []int64{1, 2}),
+				attribute.Float64Slice("metis.ratios", []float64{0.5, 1e21}),
+				attribute.BoolSlice("metis.flags", []bool{true, false}),
+			},
+			Events: []trace.Event{{
+				Name:                  "exception",
+				Attributes:            []attribute.KeyValue{attribute.String("exception.message", "boom\n")},
+				DroppedAttributeCount: 1,
+				Time:                  start.Add(time.Second),
+			}},
+			Links: []trace.Link{{
+				SpanContext:           parent,
+				Attributes:            []attribute.KeyValue{attribute.String("metis.link", "retry")},
+				DroppedAttributeCount: 2,
+			}},
+			Status:            trace.Status{Code: codes.Error, Description: "internal error"},
+			DroppedAttributes: 3,
+			DroppedEvents:     4,
+			DroppedLinks:      5,
+			ChildSpanCount:    1,
+			Resource:          res,
+			InstrumentationLibrary: instrumentation.Scope{
+				Name:      "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp",
+				Version:   "0.42.0",
+				SchemaURL: "https://opentelemetry.io/schemas/1.17.0",
+			},
+		},
+		{
+			Name: "SELECT users",
+			SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID: parent.TraceID(),
+				SpanID:  oteltrace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			}),
+			Parent:     server,
+			SpanKind:   oteltrace.SpanKindClient,
+			StartTime:  start.Add(100 * time.Millisecond),
+			EndTime:    start.Add(200 * time.Millisecond),
+			Attributes: []attribute.KeyValue{semconv.DBSystemPostgreSQL, semconv.DBStatement("SELECT * FROM users WHERE id = $1")},
+			Status:     trace.Status{Code: codes.Ok},
+			Resource:   res,
+		},
+		{
+			Name:      "no resource",
+			StartTime: time.Date(2023, 6, 1, 14, 0, 0, 0, time.FixedZone("IDT", 3*60*60)),
+		},
+	}.Snapshots()
+}
+
+// TestPayloadGolden fails on any change of the Metis JSON payload. Intended changes bump
+// PayloadSchemaVersion and add a golden file with go test -run TestPayloadGolden -update.
+func TestPayloadGolden(t *testing.T) {
+	t.Parallel()
+	enc := &spanEncoder{}
+	var encoded [][]byte
+	for _, span := range newGoldenSpans() {
+		b, err := enc.encode(span)
+		if err != nil {
+			t.Fatalf("encode() error = %v", err)
+		}
+		encoded = append(encoded, b)
+	}
+	var got bytes.Buffer
+	if err := json.Indent(&got, encodeBatch(encoded), "", "  "); err != nil {
+		t.Fatalf("json.Indent() error = %v", err)
+	}
+	got.WriteByte('\n')
+	if *update {
+		if err := os.WriteFile(goldenPayloadFile, got.Bytes(), 0o644); err != nil {
+			t.Fatalf("os.WriteFile() error = %v", err)
+		}
+	}
+	want, err := os.ReadFile(goldenPayloadFile)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if diff := cmp.Diff(string(want), got.String()); diff != "" {
+		t.Errorf("payload differs from %s, bump PayloadSchemaVersion if intended (-want +got):\n%s", goldenPayloadFile, diff)
+	}
+}
+
+// TestPayloadStructs checks the payload structs describe the golden payload exactly.
+func TestPayloadStructs(t *testing.T) {
+	t.Parallel()
+	golden, err := os.ReadFile(goldenPayloadFile)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(golden))
+	decoder.DisallowUnknownFields()
+	var spans []PayloadSpan
+	if err := decoder.Decode(&spans); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	encoded, err := json.MarshalIndent(spans, "", "  ")
+	if err != nil {
+		t.Fatalf("json.MarshalIndent() error = %v", err)
+	}
+	if diff := cmp.Diff(strings.TrimSpace(string(golden)), string(encoded)); diff != "" {
+		t.Errorf("payload structs don't round trip the golden payload (-want +got):\n%s", diff)
+	}
+	for i, span := range newGoldenSpans() {
+		for j, attr := range span.Attributes() {
+			got, err := spans[i].Attributes[j].Value.AttributeValue()
+			if err != nil {
+				t.Fatalf("AttributeValue() error = %v", err)
+			}
+			if got != attr.Value {
+				t.Errorf("AttributeValue() = %v, want %v", got.Emit(), attr.Value.Emit())
+			}
+		}
+	}
+}
+
+func TestExportSchemaVersionHeader(t *testing.T) {
+	t.Parallel()
+	for _, format := range []ExportFormat{MetisJSON, OTLPProtobuf} {
+		mm := &metisMockServer{t: t}
+		ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+		exp, err := NewExporter(
+			WithExporterURL(ts.URL),
+			WithAPIKey("test-api-key"),
+			WithExportFormat(format),
+		)
+		if err != nil {
+			t.Fatalf("NewExporter() error = %v", err)
+		}
+		t.Cleanup(func() { exp.Shutdown(context.Background()) })
+		if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+		ts.Close()
+		if len(mm.headers) != 1 {
+			t.Fatalf("format %d: expected 1 request, got %d", format, len(mm.headers))
+		}
+		want := PayloadSchemaVersion
+		if format != MetisJSON {
+			want = ""
+		}
+		if got := mm.headers[0].Get(schemaVersionHeader); got != want {
+			t.Errorf("format %d: expected schema version %q, got %q", format, want, got)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package metis
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	defaultExporterURL    = "https://ingest.metisdata.io/"
+	defaultServiceName    = "metis-go-client"
+	defaultBatchByteLimit = 150000 // 150000 bytes
+)
+
+// config holds the settings of a single Metis tracer provider.
+type config struct {
+	url                  string
+	apiKey               APIKeySource
+	serviceName          string
+	serviceVersion       string
+	environment          string
+	resource             *resource.Resource
+	resourceDetectors    []resource.Detector
+	propagators          []string
+	keepGlobalPropagator bool
+	signalGrace          time.Duration
+	// disabled is set by OTEL_SDK_DISABLED, NewTracerProvider records nothing.
+	disabled            bool
+	batchByteLimit      int
+	batchOptions        []trace.BatchSpanProcessorOption
+	filter              SpanFilter
+	errorHandler        ErrorHandler
+	retry               RetryConfig
+	compression         Compression
+	compressedLimit     bool
+	format              ExportFormat
+	traceAssembly       *TraceAssemblyConfig
+	tailSampling        *TailSamplingConfig
+	spool               *SpoolConfig
+	exportQueue         *ExportQueueConfig
+	meterProvider       metric.MeterProvider
+	statsReader         *StatsReader
+	truncatedAttributes []string
+	maxExportDelay      time.Duration
+	connectTimeout      time.Duration
+	responseTimeout     time.Duration
+	httpClient          *http.Client
+	transport           http.RoundTripper
+	proxyURL            string
+	caFile              string
+	certFile            string
+	keyFile             string
+	serverName          string
+}
+
+// Option configures a Metis tracer provider.
+type Option func(*config)
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		batchByteLimit:      defaultBatchByteLimit,
+		errorHandler:        NewLogErrorHandler(nil),
+		retry:               DefaultRetryConfig,
+		truncatedAttributes: DefaultTruncatedAttributes,
+		connectTimeout:      defaultConnectTimeout,
+		responseTimeout:     defaultResponseTimeout,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.url == "" {
+		cfg.url = defaultExporterURL
+	}
+	return cfg
+}
+
+// envOptions returns the options set by the METIS_* and OTEL_SDK_DISABLED environment variables.
+func envOptions() []Option {
+	var opts []Option
+	if url := os.Getenv("METIS_EXPORTER_URL"); url != "" {
+		opts = append(opts, WithExporterURL(url))
+	}
+	if apiKey := os.Getenv("METIS_API_KEY"); apiKey != "" {
+		opts = append(opts, WithAPIKey(apiKey))
+	}
+	if serviceName := os.Getenv("METIS_SERVICE_NAME"); serviceName != "" {
+		opts = append(opts, WithServiceName(serviceName))
+	}
+	if environment := os.Getenv("METIS_ENVIRONMENT"); environment != "" {
+		opts = append(opts, WithDeploymentEnvironment(environment))
+	}
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		opts = append(opts, func(c *config) {
+			c.disabled = true
+		})
+	}
+	return opts
+}
+
+// WithExporterURL sets the url spans are exported to.
+// It overrides the METIS_EXPORTER_URL environment variable.
+func WithExporterURL(url string) Option {
+	return func(c *config) {
+		c.url = url
+	}
+}
+
+// WithAPIKey sets the Metis api key.
+// It overrides the METIS_API_KEY environment variable.
+func WithAPIKey(apiKey string) Option {
+	return func(c *config) {
+		c.apiKey = nil
+		if apiKey != "" {
+			c.apiKey = StaticAPIKey(apiKey)
+		}
+	}
+}
+
+// WithAPIKeySource resolves the Metis api key from source before the uploads, for keys
+// rotating in a file or a secret manager. It overrides the METIS_API_KEY environment variable.
+func WithAPIKeySource(source APIKeySource) Option {
+	return func(c *config) {
+		c.apiKey = source
+	}
+}
+
+// WithServiceName sets the service name reported with every span.
+// It overrides the METIS_SERVICE_NAME and OTEL_SERVICE_NAME environment variables.
+func WithServiceName(name string) Option {
+	return func(c *config) {
+		c.serviceName = name
+	}
+}
+
+// WithServiceVersion sets the service version reported with every span.
+// By default it is the version of the main module, when built from a tagged module.
+func WithServiceVersion(version string) Option {
+	return func(c *config) {
+		c.serviceVersion = version
+	}
+}
+
+// WithDeploymentEnvironment sets the deployment.environment reported with every span,
+// for example "staging" or "production". It overrides the METIS_ENVIRONMENT environment variable.
+func WithDeploymentEnvironment(environment string) Option {
+	return func(c *config) {
+		c.environment = environment
+	}
+}
+
+// WithResource sets the resource of the tracer provider instead of detecting it, the service
+// options still take precedence over its attributes.
+func WithResource(res *resource.Resource) Option {
+	return func(c *config) {
+		c.resource = res
+	}
+}
+
+// WithResourceDetectors adds detectors to the resource of the tracer provider, their
+// attributes take precedence over the detected ones but not over the OTEL_* environment
+// variables and the service options.
+func WithResourceDetectors(detectors ...resource.Detector) Option {
+	return func(c *config) {
+		c.resourceDetectors = append(c.resourceDetectors, detectors...)
+	}
+}
+
+// WithPropagators sets the global propagator installed by NewTracerProvider, a composite of
+// the named propagators: "tracecontext", "baggage", "b3" (single header), "b3multi", "jaeger"
+// or "none". It overrides the OTEL_PROPAGATORS environment variable, the default is "tracecontext".
+func WithPropagators(names ...string) Option {
+	return func(c *config) {
+		c.propagators = append([]string{}, names...)
+	}
+}
+
+// WithoutGlobalPropagator leaves the global propagator of the application untouched,
+// NewTracerProvider then ignores WithPropagators and OTEL_PROPAGATORS.
+func WithoutGlobalPropagator() Option {
+	return func(c *config) {
+		c.keepGlobalPropagator = true
+	}
+}
+
+// WithShutdownOnSignal makes Start shut down the tracer provider on SIGTERM or SIGINT, waiting
+// up to grace for the spans to be flushed, then raise the signal again. Applications handling
+// these signals themselves should call the shutdown returned by Start instead.
+// It is ignored by NewTracerProvider.
+func WithShutdownOnSignal(grace time.Duration) Option {
+	return func(c *config) {
+		c.signalGrace = grace
+	}
+}
+
+// WithBatchByteLimit sets the maximum size in bytes of a single export request, it must be positive.
+func WithBatchByteLimit(limit int) Option {
+	return func(c *config) {
+		c.batchByteLimit = limit
+	}
+}
+
+// WithBatchSpanProcessorOptions sets the options of the underlying batch span processor.
+func WithBatchSpanProcessorOptions(opts ...trace.BatchSpanProcessorOption) Option {
+	return func(c *config) {
+		c.batchOptions = append(c.batchOptions, opts...)
+	}
+}
+
+// WithSpanFilter sets the filter deciding which spans are exported.
+// By default HTTP server spans are exported, together with the DB spans of their traces.
+func WithSpanFilter(filter SpanFilter) Option {
+	return func(c *config) {
+		c.filter = filter
+	}
+}
+
+// WithMaxExportDelay bounds the time ended spans wait before they are sent, useful for short
+// lived programs such as CLI tools and serverless handlers. Spans wait at most d in the batch
+// span processor, overriding its batch timeout, and traces are held at most d by trace assembly.
+// Call ForceFlush on the tracer provider to send every span right away.
+func WithMaxExportDelay(d time.Duration) Option {
+	return func(c *config) {
+		c.maxExportDelay = d
+	}
+}
+
+// WithErrorHandler sets the handler errors raised while exporting spans are reported to.
+// By default errors are written to stderr, use NewSentryErrorHandler to report them to Sentry.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(c *config) {
+		c.errorHandler = handler
+	}
+}
+
+// WithConnectTimeout bounds the time spent connecting to the exporter url, TLS handshake
+// included. The default is 10 seconds.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.connectTimeout = d
+	}
+}
+
+// WithResponseTimeout bounds the time waiting for the response headers once a request is sent.
+// The default is 30 seconds.
+func WithResponseTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.responseTimeout = d
+	}
+}
+
+// WithHTTPClient sets the client uploading the batches. It is used as is, the timeout,
+// transport, proxy and TLS options are ignored.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.httpClient = client
+	}
+}
+
+// WithTransport sets the transport of the client uploading the batches.
+// The timeout, proxy and TLS options are ignored.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *config) {
+		c.transport = transport
+	}
+}
+
+// WithProxy sends the uploads through the proxy at proxyURL, for example "http://proxy:3128".
+// By default the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+func WithProxy(proxyURL string) Option {
+	return func(c *config) {
+		c.proxyURL = proxyURL
+	}
+}
+
+// WithCAFile adds the PEM certificates of path to the system roots trusted to verify
+// the exporter url and the proxy.
+func WithCAFile(path string) Option {
+	return func(c *config) {
+		c.caFile = path
+	}
+}
+
+// WithClientCertificate authenticates the uploads with the PEM certificate and key of
+// certFile and keyFile, for mTLS endpoints.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *config) {
+		c.certFile = certFile
+		c.keyFile = keyFile
+	}
+}
+
+// WithServerName sets the name sent in the TLS handshake (SNI) and expected in the certificate
+// of the exporter url, when it differs from the url host.
+func WithServerName(name string) Option {
+	return func(c *config) {
+		c.serverName = name
+	}
+}
+
+// WithRetry sets the retry configuration of span uploads.
+// By default DefaultRetryConfig is used, pass RetryConfig{} to disable retries.
+// The zero fields of an enabled configuration take their value from DefaultRetryConfig.
+func WithRetry(rc RetryConfig) Option {
+	return func(c *config) {
+		c.retry = rc
+	}
+}
+
+// WithCompression sets the compression of export request bodies.
+func WithCompression(compression Compression) Option {
+	return func(c *config) {
+		c.compression = compression
+	}
+}
+
+// WithExportFormat sets the payload format of export requests, MetisJSON by default.
+// With OTLPProtobuf or OTLPJSON the exporter can also send spans to an OpenTelemetry Collector,
+// for example with WithExporterURL("http://localhost:4318/v1/traces").
+func WithExportFormat(format ExportFormat) Option {
+	return func(c *config) {
+		c.format = format
+	}
+}
+
+// WithCompressedBatchLimit applies the batch byte limit to the compressed request body
+// instead of the uncompressed one, so each request carries more spans.
+// It has no effect without WithCompression.
+func WithCompressedBatchLimit() Option {
+	return func(c *config) {
+		c.compressedLimit = true
+	}
+}
+
+// WithTraceAssembly holds spans until their trace is complete, so each trace is exported
+// in a single batch. Zero fields of cfg take their value from DefaultTraceAssemblyConfig.
+func WithTraceAssembly(cfg TraceAssemblyConfig) Option {
+	return func(c *config) {
+		c.traceAssembly = &cfg
+	}
+}
+
+// WithTailSampling exports only the traces kept by the tail sampling policies of cfg.
+// Traces have to be complete to be sampled, so it enables trace assembly with
+// DefaultTraceAssemblyConfig unless WithTraceAssembly is set.
+func WithTailSampling(cfg TailSamplingConfig) Option {
+	return func(c *config) {
+		c.tailSampling = &cfg
+	}
+}
+
+// WithSpool keeps the batches that still fail to export after retries in a spool directory,
+// and replays them once the endpoint answers again.
+func WithSpool(cfg SpoolConfig) Option {
+	return func(c *config) {
+		c.spool = &cfg
+	}
+}
+
+// WithExportQueue makes ExportSpans non-blocking: batches are queued and uploaded by a pool of
+// workers. Zero fields of cfg take their value from DefaultExportQueueConfig, see
+// Stats.Dropped for the spans dropped when the queue is full.
+func WithExportQueue(cfg ExportQueueConfig) Option {
+	return func(c *config) {
+		c.exportQueue = &cfg
+	}
+}
+
+// WithMeterProvider records the exporter metrics, such as the received, exported and dropped
+// spans, with mp. By default no metrics are recorded, the Stats of WithStatsReader are always available.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+// WithStatsReader makes r read the Stats of the exporter, for example for health checks.
+func WithStatsReader(r *StatsReader) Option {
+	return func(c *config) {
+		c.statsReader = r
+	}
+}
+
+// WithTruncatedAttributes sets the attributes truncated, with TruncationMarker, when a span alone
+// exceeds the batch byte limit. A key ending with * matches every attribute starting with the prefix.
+// Spans still exceeding the limit are dropped, pass no key to drop oversized spans without truncating
+// them. By default DefaultTruncatedAttributes are truncated.
+func WithTruncatedAttributes(keys ...string) Option {
+	return func(c *config) {
+		c.truncatedAttributes = keys
+	}
+}
@@ -0,0 +1,580 @@
+package metis
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpEncoder encodes spans for the OTLP formats. OTLP groups spans by resource and
+// instrumentation scope, so each span is encoded as a frame of length prefixed parts:
+// its resource, the resource schema url, its scope, the scope schema url and the span itself.
+// encodeOTLPBatch and encodeOTLPJSONBatch group the frames of a batch into a request.
+type otlpEncoder struct {
+	json bool
+	buf  []byte
+	// resource and resourceBytes cache the encoding of the last seen resource.
+	resource      *resource.Resource
+	resourceBytes []byte
+}
+
+// Parts of an OTLP span frame in order, frameParts is their number.
+const (
+	frameResource = iota
+	frameResourceSchemaURL
+	frameScope
+	frameScopeSchemaURL
+	frameSpan
+	frameParts
+)
+
+func (e *otlpEncoder) encode(span trace.ReadOnlySpan) ([]byte, error) {
+	res := span.Resource()
+	if res != e.resource || e.resourceBytes == nil {
+		var err error
+		if e.resourceBytes, err = e.encodeResource(res); err != nil {
+			return nil, err
+		}
+		e.resource = res
+	}
+	scope := span.InstrumentationScope()
+	b := protowire.AppendBytes(e.buf[:0], e.resourceBytes)
+	b = protowire.AppendString(b, res.SchemaURL())
+	if e.json {
+		scopeJSON := append(append([]byte(`{"name":`), appendString(nil, scope.Name)...), `,"version":`...)
+		scopeJSON = append(appendString(scopeJSON, scope.Version), '}')
+		b = protowire.AppendBytes(b, scopeJSON)
+	} else {
+		scopeBytes, err := proto.Marshal(&commonpb.InstrumentationScope{Name: scope.Name, Version: scope.Version})
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendBytes(b, scopeBytes)
+	}
+	b = protowire.AppendString(b, scope.SchemaURL)
+	var spanBytes []byte
+	var err error
+	if e.json {
+		spanBytes, err = appendOTLPJSONSpan(nil, span)
+	} else {
+		spanBytes, err = proto.Marshal(otlpSpan(span))
+	}
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendBytes(b, spanBytes)
+	e.buf = b
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out, nil
+}
+
+func (e *otlpEncoder) encodeResource(res *resource.Resource) ([]byte, error) {
+	if e.json {
+		b, err := appendOTLPJSONAttributes(append([]byte(nil), `{"attributes":`...), res.Attributes())
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '}'), nil
+	}
+	return proto.Marshal(&resourcepb.Resource{Attributes: otlpAttributes(res.Attributes())})
+}
+
+// otlpScopeGroup holds the spans of a batch sharing an instrumentation scope.
+type otlpScopeGroup struct {
+	scope     []byte
+	schemaURL []byte
+	spans     [][]byte
+}
+
+// otlpResourceGroup holds the spans of a batch sharing a resource.
+type otlpResourceGroup struct {
+	resource  []byte
+	schemaURL []byte
+	scopes    []*otlpScopeGroup
+}
+
+// groupOTLPFrames groups span frames by resource and scope, in the order they first appear.
+func groupOTLPFrames(frames [][]byte) ([]*otlpResourceGroup, error) {
+	var groups []*otlpResourceGroup
+	resources := map[string]*otlpResourceGroup{}
+	scopes := map[string]*otlpScopeGroup{}
+	for _, frame := range frames {
+		parts, err := splitOTLPFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+		resourceKey := string(parts[frameResource]) + "\x00" + string(parts[frameResourceSchemaURL])
+		rg, ok := resources[resourceKey]
+		if !ok {
+			rg = &otlpResourceGroup{resource: parts[frameResource], schemaURL: parts[frameResourceSchemaURL]}
+			resources[resourceKey] = rg
+			groups = append(groups, rg)
+		}
+		scopeKey := resourceKey + "\x00" + string(parts[frameScope]) + "\x00" + string(parts[frameScopeSchemaURL])
+		sg, ok := scopes[scopeKey]
+		if !ok {
+			sg = &otlpScopeGroup{scope: parts[frameScope], schemaURL: parts[frameScopeSchemaURL]}
+			scopes[scopeKey] = sg
+			rg.scopes = append(rg.scopes, sg)
+		}
+		sg.spans = append(sg.spans, parts[frameSpan])
+	}
+	return groups, nil
+}
+
+// splitOTLPFrame returns the parts of a span frame.
+func splitOTLPFrame(frame []byte) ([frameParts][]byte, error) {
+	var parts [frameParts][]byte
+	for i := range parts {
+		part, n := protowire.ConsumeBytes(frame)
+		if n < 0 {
+			return parts, errors.New("invalid OTLP span frame")
+		}
+		parts[i] = part
+		frame = frame[n:]
+	}
+	return parts, nil
+}
+
+// otlpBatchSizer measures the bytes span frames add to an OTLP request. A request holds each
+// resource and scope once, they are counted with the first span sharing them.
+type otlpBatchSizer struct {
+	json      bool
+	resources map[string]bool
+	scopes    map[string]bool
+}
+
+func newOTLPBatchSizer(json bool) *otlpBatchSizer {
+	return &otlpBatchSizer{json: json, resources: map[string]bool{}, scopes: map[string]bool{}}
+}
+
+// add returns the number of bytes frame adds to the batch, an upper bound when it starts a
+// resource or a scope group.
+func (s *otlpBatchSizer) add(frame []byte) (int, error) {
+	parts, err := splitOTLPFrame(frame)
+	if err != nil {
+		return 0, err
+	}
+	size := 0
+	if len(s.resources) == 0 && s.json {
+		size += len(`{"resourceSpans":[]}`)
+	}
+	resourceKey := string(parts[frameResource]) + "\x00" + string(parts[frameResourceSchemaURL])
+	if !s.resources[resourceKey] {
+		s.resources[resourceKey] = true
+		size += s.groupSize(`{"resource":,"scopeSpans":[]},`, parts[frameResource], parts[frameResourceSchemaURL])
+	}
+	scopeKey := resourceKey + "\x00" + string(parts[frameScope]) + "\x00" + string(parts[frameScopeSchemaURL])
+	if !s.scopes[scopeKey] {
+		s.scopes[scopeKey] = true
+		size += s.groupSize(`{"scope":,"spans":[]},`, parts[frameScope], parts[frameScopeSchemaURL])
+	}
+	if s.json {
+		// and the separating comma
+		return size + len(parts[frameSpan]) + 1, nil
+	}
+	return size + 1 + protowire.SizeBytes(len(parts[frameSpan])), nil
+}
+
+// groupSize returns the size of a resource or scope group without its spans, jsonFrame being the
+// JSON encoding of the group without its value.
+func (s *otlpBatchSizer) groupSize(jsonFrame string, value, schemaURL []byte) int {
+	if s.json {
+		size := len(jsonFrame) + len(value)
+		if len(schemaURL) > 0 {
+			size += len(`,"schemaUrl":`) + len(appendString(nil, string(schemaURL)))
+		}
+		return size
+	}
+	// the tag and the length of the group, which grows with its spans
+	size := 1 + binary.MaxVarintLen32 + 1 + protowire.SizeBytes(len(value))
+	if len(schemaURL) > 0 {
+		size += 1 + protowire.SizeBytes(len(schemaURL))
+	}
+	return size
+}
+
+func (s *otlpBatchSizer) reset() {
+	s.resources = map[string]bool{}
+	s.scopes = map[string]bool{}
+}
+
+// encodeOTLPBatch joins span frames into an ExportTraceServiceRequest protobuf message.
+// Its only field, resource_spans, has the same number as in TracesData.
+func encodeOTLPBatch(frames [][]byte) ([]byte, error) {
+	groups, err := groupOTLPFrames(frames)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, rg := range groups {
+		rs := protowire.AppendTag(nil, 1, protowire.BytesType)
+		rs = protowire.AppendBytes(rs, rg.resource)
+		for _, sg := range rg.scopes {
+			ss := protowire.AppendTag(nil, 1, protowire.BytesType)
+			ss = protowire.AppendBytes(ss, sg.scope)
+			for _, span := range sg.spans {
+				ss = protowire.AppendTag(ss, 2, protowire.BytesType)
+				ss = protowire.AppendBytes(ss, span)
+			}
+			if len(sg.schemaURL) > 0 {
+				ss = protowire.AppendTag(ss, 3, protowire.BytesType)
+				ss = protowire.AppendBytes(ss, sg.schemaURL)
+			}
+			rs = protowire.AppendTag(rs, 2, protowire.BytesType)
+			rs = protowire.AppendBytes(rs, ss)
+		}
+		if len(rg.schemaURL) > 0 {
+			rs = protowire.AppendTag(rs, 3, protowire.BytesType)
+			rs = protowire.AppendBytes(rs, rg.schemaURL)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, rs)
+	}
+	return b, nil
+}
+
+// encodeOTLPJSONBatch joins span frames into an ExportTraceServiceRequest JSON message.
+func encodeOTLPJSONBatch(frames [][]byte) ([]byte, error) {
+	groups, err := groupOTLPFrames(frames)
+	if err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), `{"resourceSpans":[`...)
+	for i, rg := range groups {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"resource":`...)
+		b = append(b, rg.resource...)
+		b = append(b, `,"scopeSpans":[`...)
+		for j, sg := range rg.scopes {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, `{"scope":`...)
+			b = append(b, sg.scope...)
+			b = append(b, `,"spans":[`...)
+			for k, span := range sg.spans {
+				if k > 0 {
+					b = append(b, ',')
+				}
+				b = append(b, span...)
+			}
+			b = append(b, ']')
+			if len(sg.schemaURL) > 0 {
+				b = append(b, `,"schemaUrl":`...)
+				b = appendString(b, string(sg.schemaURL))
+			}
+			b = append(b, '}')
+		}
+		b = append(b, ']')
+		if len(rg.schemaURL) > 0 {
+			b = append(b, `,"schemaUrl":`...)
+			b = appendString(b, string(rg.schemaURL))
+		}
+		b = append(b, '}')
+	}
+	return append(b, "]}"...), nil
+}
+
+// otlpSpan converts span to its OTLP protobuf message.
+func otlpSpan(span trace.ReadOnlySpan) *tracepb.Span {
+	sc := span.SpanContext()
+	traceID := sc.TraceID()
+	spanID := sc.SpanID()
+	s := &tracepb.Span{
+		TraceId:                traceID[:],
+		SpanId:                 spanID[:],
+		TraceState:             sc.TraceState().String(),
+		Name:                   span.Name(),
+		Kind:                   tracepb.Span_SpanKind(span.SpanKind()),
+		StartTimeUnixNano:      unixNano(span.StartTime()),
+		EndTimeUnixNano:        unixNano(span.EndTime()),
+		Attributes:             otlpAttributes(span.Attributes()),
+		DroppedAttributesCount: uint32(span.DroppedAttributes()),
+		DroppedEventsCount:     uint32(span.DroppedEvents()),
+		DroppedLinksCount:      uint32(span.DroppedLinks()),
+		Status: &tracepb.Status{
+			Code:    otlpStatusCode(span.Status().Code),
+			Message: span.Status().Description,
+		},
+	}
+	if parent := span.Parent(); parent.SpanID().IsValid() {
+		parentID := parent.SpanID()
+		s.ParentSpanId = parentID[:]
+	}
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, &tracepb.Span_Event{
+			TimeUnixNano:           unixNano(event.Time),
+			Name:                   event.Name,
+			Attributes:             otlpAttributes(event.Attributes),
+			DroppedAttributesCount: uint32(event.DroppedAttributeCount),
+		})
+	}
+	for _, link := range span.Links() {
+		linkTraceID := link.SpanContext.TraceID()
+		linkSpanID := link.SpanContext.SpanID()
+		s.Links = append(s.Links, &tracepb.Span_Link{
+			TraceId:                linkTraceID[:],
+			SpanId:                 linkSpanID[:],
+			TraceState:             link.SpanContext.TraceState().String(),
+			Attributes:             otlpAttributes(link.Attributes),
+			DroppedAttributesCount: uint32(link.DroppedAttributeCount),
+		})
+	}
+	return s
+}
+
+func otlpStatusCode(code codes.Code) tracepb.Status_StatusCode {
+	switch code {
+	case codes.Ok:
+		return tracepb.Status_STATUS_CODE_OK
+	case codes.Error:
+		return tracepb.Status_STATUS_CODE_ERROR
+	}
+	return tracepb.Status_STATUS_CODE_UNSET
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		out = append(out, &commonpb.KeyValue{Key: string(attr.Key), Value: otlpValue(attr.Value)})
+	}
+	return out
+}
+
+func otlpValue(v attribute.Value) *commonpb.AnyValue {
+	av := &commonpb.AnyValue{}
+	switch v.Type() {
+	case attribute.BOOL:
+		av.Value = &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}
+	case attribute.INT64:
+		av.Value = &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}
+	case attribute.FLOAT64:
+		av.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}
+	case attribute.STRING:
+		av.Value = &commonpb.AnyValue_StringValue{StringValue: v.AsString()}
+	case attribute.BOOLSLICE, attribute.INT64SLICE, attribute.FLOAT64SLICE, attribute.STRINGSLICE:
+		values := &commonpb.ArrayValue{}
+		for _, elem := range sliceValues(v) {
+			values.Values = append(values.Values, otlpValue(elem))
+		}
+		av.Value = &commonpb.AnyValue_ArrayValue{ArrayValue: values}
+	default:
+		av.Value = &commonpb.AnyValue_StringValue{StringValue: v.Emit()}
+	}
+	return av
+}
+
+// sliceValues returns the elements of a slice value.
+func sliceValues(v attribute.Value) []attribute.Value {
+	var values []attribute.Value
+	switch v.Type() {
+	case attribute.BOOLSLICE:
+		for _, elem := range v.AsBoolSlice() {
+			values = append(values, attribute.BoolValue(elem))
+		}
+	case attribute.INT64SLICE:
+		for _, elem := range v.AsInt64Slice() {
+			values = append(values, attribute.Int64Value(elem))
+		}
+	case attribute.FLOAT64SLICE:
+		for _, elem := range v.AsFloat64Slice() {
+			values = append(values, attribute.Float64Value(elem))
+		}
+	case attribute.STRINGSLICE:
+		for _, elem := range v.AsStringSlice() {
+			values = append(values, attribute.StringValue(elem))
+		}
+	}
+	return values
+}
+
+// appendOTLPJSONSpan appends the OTLP JSON encoding of span, following the OTLP/HTTP JSON
+// mapping: hex trace and span ids, integer enums and 64 bit integers as strings.
+func appendOTLPJSONSpan(b []byte, span trace.ReadOnlySpan) ([]byte, error) {
+	var err error
+	sc := span.SpanContext()
+	traceID := sc.TraceID()
+	spanID := sc.SpanID()
+	b = append(b, `{"traceId":"`...)
+	b = appendHex(b, traceID[:])
+	b = append(b, `","spanId":"`...)
+	b = appendHex(b, spanID[:])
+	b = append(b, '"')
+	if ts := sc.TraceState().String(); ts != "" {
+		b = append(b, `,"traceState":`...)
+		b = appendString(b, ts)
+	}
+	if parent := span.Parent(); parent.SpanID().IsValid() {
+		parentID := parent.SpanID()
+		b = append(b, `,"parentSpanId":"`...)
+		b = appendHex(b, parentID[:])
+		b = append(b, '"')
+	}
+	b = append(b, `,"name":`...)
+	b = appendString(b, span.Name())
+	b = append(b, `,"kind":`...)
+	b = strconv.AppendInt(b, int64(span.SpanKind()), 10)
+	b = append(b, `,"startTimeUnixNano":"`...)
+	b = strconv.AppendUint(b, unixNano(span.StartTime()), 10)
+	b = append(b, `","endTimeUnixNano":"`...)
+	b = strconv.AppendUint(b, unixNano(span.EndTime()), 10)
+	b = append(b, `","attributes":`...)
+	if b, err = appendOTLPJSONAttributes(b, span.Attributes()); err != nil {
+		return nil, err
+	}
+	b = appendOTLPJSONCount(b, "droppedAttributesCount", span.DroppedAttributes())
+	b = append(b, `,"events":[`...)
+	for i, event := range span.Events() {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"timeUnixNano":"`...)
+		b = strconv.AppendUint(b, unixNano(event.Time), 10)
+		b = append(b, `","name":`...)
+		b = appendString(b, event.Name)
+		b = append(b, `,"attributes":`...)
+		if b, err = appendOTLPJSONAttributes(b, event.Attributes); err != nil {
+			return nil, err
+		}
+		b = appendOTLPJSONCount(b, "droppedAttributesCount", event.DroppedAttributeCount)
+		b = append(b, '}')
+	}
+	b = append(b, ']')
+	b = appendOTLPJSONCount(b, "droppedEventsCount", span.DroppedEvents())
+	b = append(b, `,"links":[`...)
+	for i, link := range span.Links() {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		linkTraceID := link.SpanContext.TraceID()
+		linkSpanID := link.SpanContext.SpanID()
+		b = append(b, `{"traceId":"`...)
+		b = appendHex(b, linkTraceID[:])
+		b = append(b, `","spanId":"`...)
+		b = appendHex(b, linkSpanID[:])
+		b = append(b, '"')
+		if ts := link.SpanContext.TraceState().String(); ts != "" {
+			b = append(b, `,"traceState":`...)
+			b = appendString(b, ts)
+		}
+		b = append(b, `,"attributes":`...)
+		if b, err = appendOTLPJSONAttributes(b, link.Attributes); err != nil {
+			return nil, err
+		}
+		b = appendOTLPJSONCount(b, "droppedAttributesCount", link.DroppedAttributeCount)
+		b = append(b, '}')
+	}
+	b = append(b, ']')
+	b = appendOTLPJSONCount(b, "droppedLinksCount", span.DroppedLinks())
+	b = append(b, `,"status":{`...)
+	if description := span.Status().Description; description != "" {
+		b = append(b, `"message":`...)
+		b = appendString(b, description)
+		b = append(b, ',')
+	}
+	b = append(b, `"code":`...)
+	b = strconv.AppendInt(b, int64(otlpStatusCode(span.Status().Code)), 10)
+	return append(b, "}}"...), nil
+}
+
+// appendOTLPJSONCount appends a dropped count field, zero counts are left out.
+func appendOTLPJSONCount(b []byte, name string, n int) []byte {
+	if n == 0 {
+		return b
+	}
+	b = append(b, `,"`...)
+	b = append(b, name...)
+	b = append(b, `":`...)
+	return strconv.AppendInt(b, int64(n), 10)
+}
+
+func appendOTLPJSONAttributes(b []byte, attrs []attribute.KeyValue) ([]byte, error) {
+	var err error
+	b = append(b, '[')
+	for i, attr := range attrs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"key":`...)
+		b = appendString(b, string(attr.Key))
+		b = append(b, `,"value":`...)
+		if b, err = appendOTLPJSONValue(b, attr.Value); err != nil {
+			return nil, err
+		}
+		b = append(b, '}')
+	}
+	return append(b, ']'), nil
+}
+
+func appendOTLPJSONValue(b []byte, v attribute.Value) ([]byte, error) {
+	switch v.Type() {
+	case attribute.BOOL:
+		b = append(b, `{"boolValue":`...)
+		b = strconv.AppendBool(b, v.AsBool())
+	case attribute.INT64:
+		b = append(b, `{"intValue":"`...)
+		b = strconv.AppendInt(b, v.AsInt64(), 10)
+		b = append(b, '"')
+	case attribute.FLOAT64:
+		b = append(b, `{"doubleValue":`...)
+		f := v.AsFloat64()
+		switch {
+		case math.IsNaN(f):
+			b = append(b, `"NaN"`...)
+		case math.IsInf(f, 1):
+			b = append(b, `"Infinity"`...)
+		case math.IsInf(f, -1):
+			b = append(b, `"-Infinity"`...)
+		default:
+			var err error
+			if b, err = appendFloat(b, f); err != nil {
+				return nil, err
+			}
+		}
+	case attribute.STRING:
+		b = append(b, `{"stringValue":`...)
+		b = appendString(b, v.AsString())
+	case attribute.BOOLSLICE, attribute.INT64SLICE, attribute.FLOAT64SLICE, attribute.STRINGSLICE:
+		b = append(b, `{"arrayValue":{"values":[`...)
+		for i, elem := range sliceValues(v) {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			var err error
+			if b, err = appendOTLPJSONValue(b, elem); err != nil {
+				return nil, err
+			}
+		}
+		b = append(b, "]}"...)
+	default:
+		b = append(b, `{"stringValue":`...)
+		b = appendString(b, v.Emit())
+	}
+	return append(b, '}'), nil
+}
@@ -0,0 +1,254 @@
+package metis
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func newOTLPTestSpans() []trace.ReadOnlySpan {
+	res := resource.NewSchemaless(attribute.String("service.name", "balagan"))
+	traceID := oteltrace.TraceID{1}
+	return tracetest.SpanStubs{
+		{
+			Name: "GET /users",
+			SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID: traceID, SpanID: oteltrace.SpanID{1},
+			}),
+			SpanKind:               oteltrace.SpanKindServer,
+			Attributes:             []attribute.KeyValue{attribute.String("http.route", "/users"), attribute.Int("http.status_code", 500)},
+			Status:                 trace.Status{Code: codes.Error, Description: "boom"},
+			Resource:               res,
+			InstrumentationLibrary: instrumentation.Scope{Name: "otelhttp", Version: "v0.42.0"},
+		},
+		{
+			Name: "SELECT users",
+			SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID: traceID, SpanID: oteltrace.SpanID{2},
+			}),
+			Parent: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID: traceID, SpanID: oteltrace.SpanID{1},
+			}),
+			SpanKind:               oteltrace.SpanKindClient,
+			Attributes:             []attribute.KeyValue{attribute.StringSlice("db.tables", []string{"users"})},
+			Resource:               res,
+			InstrumentationLibrary: instrumentation.Scope{Name: "otelsql"},
+		},
+		{
+			Name: "GET /health",
+			SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID: oteltrace.TraceID{2}, SpanID: oteltrace.SpanID{3},
+			}),
+			SpanKind:               oteltrace.SpanKindServer,
+			Resource:               res,
+			InstrumentationLibrary: instrumentation.Scope{Name: "otelhttp", Version: "v0.42.0"},
+		},
+	}.Snapshots()
+}
+
+func exportOTLP(t *testing.T, format ExportFormat) (string, http.Header) {
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithExportFormat(format),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	if err := exp.ExportSpans(context.Background(), newOTLPTestSpans()); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if len(mm.spans) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(mm.spans))
+	}
+	return mm.spans[0], mm.headers[0]
+}
+
+func TestExporterOTLPProtobuf(t *testing.T) {
+	t.Parallel()
+	body, header := exportOTLP(t, OTLPProtobuf)
+	if got := header.Get("Content-Type"); got != "application/x-protobuf" {
+		t.Errorf("expected protobuf content type, got %s", got)
+	}
+	// TracesData has the same fields as ExportTraceServiceRequest
+	var data tracepb.TracesData
+	if err := proto.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("proto.Unmarshal() error = %v", err)
+	}
+	if len(data.ResourceSpans) != 1 {
+		t.Fatalf("expected 1 resource, got %d", len(data.ResourceSpans))
+	}
+	rs := data.ResourceSpans[0]
+	if attrs := rs.Resource.Attributes; len(attrs) != 1 || attrs[0].Value.GetStringValue() != "balagan" {
+		t.Errorf("unexpected resource attributes %v", attrs)
+	}
+	if len(rs.ScopeSpans) != 2 {
+		t.Fatalf("expected 2 scopes, got %d", len(rs.ScopeSpans))
+	}
+	http, db := rs.ScopeSpans[0], rs.ScopeSpans[1]
+	if http.Scope.Name != "otelhttp" || http.Scope.Version != "v0.42.0" || len(http.Spans) != 2 {
+		t.Errorf("expected the 2 otelhttp spans in the first scope, got %v", http)
+	}
+	if db.Scope.Name != "otelsql" || len(db.Spans) != 1 {
+		t.Fatalf("expected the otelsql span in the second scope, got %v", db)
+	}
+	server := http.Spans[0]
+	if server.Name != "GET /users" || server.Kind != tracepb.Span_SPAN_KIND_SERVER ||
+		server.Status.Code != tracepb.Status_STATUS_CODE_ERROR || server.Status.Message != "boom" ||
+		server.Attributes[1].Value.GetIntValue() != 500 || len(server.ParentSpanId) != 0 {
+		t.Errorf("unexpected server span %v", server)
+	}
+	query := db.Spans[0]
+	if query.Kind != tracepb.Span_SPAN_KIND_CLIENT || hex.EncodeToString(query.ParentSpanId) != "0100000000000000" ||
+		query.Attributes[0].Value.GetArrayValue().Values[0].GetStringValue() != "users" {
+		t.Errorf("unexpected query span %v", query)
+	}
+}
+
+func TestExporterOTLPJSON(t *testing.T) {
+	t.Parallel()
+	body, header := exportOTLP(t, OTLPJSON)
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected JSON content type, got %s", got)
+	}
+	type anyValue struct {
+		StringValue *string
+		IntValue    *string
+		ArrayValue  *struct{ Values []anyValue }
+	}
+	type keyValue struct {
+		Key   string
+		Value anyValue
+	}
+	var request struct {
+		ResourceSpans []struct {
+			Resource   struct{ Attributes []keyValue }
+			ScopeSpans []struct {
+				Scope struct{ Name, Version string }
+				Spans []struct {
+					TraceID, SpanID, ParentSpanID, Name, StartTimeUnixNano string
+					Kind                                                   int
+					Attributes                                             []keyValue
+					Status                                                 struct {
+						Message string
+						Code    int
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, body %s", err, body)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 2 {
+		t.Fatalf("expected 1 resource with 2 scopes, got %s", body)
+	}
+	scopes := request.ResourceSpans[0].ScopeSpans
+	server, query := scopes[0].Spans[0], scopes[1].Spans[0]
+	if server.TraceID != "01000000000000000000000000000000" || server.SpanID != "0100000000000000" ||
+		server.Kind != 2 || server.Status.Code != 2 || server.Status.Message != "boom" ||
+		*server.Attributes[1].Value.IntValue != "500" || server.StartTimeUnixNano != "0" {
+		t.Errorf("unexpected server span %+v", server)
+	}
+	if query.ParentSpanID != "0100000000000000" || *query.Attributes[0].Value.ArrayValue.Values[0].StringValue != "users" {
+		t.Errorf("unexpected query span %+v", query)
+	}
+	if len(scopes[0].Spans) != 2 || scopes[0].Scope.Version != "v0.42.0" {
+		t.Errorf("expected the 2 otelhttp spans in the first scope, got %+v", scopes[0])
+	}
+}
+
+// otlpRequestSpans returns the encoded spans of an OTLP request body.
+func otlpRequestSpans(t *testing.T, format ExportFormat, body string) [][]byte {
+	var spans [][]byte
+	if format == OTLPJSON {
+		var data struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []json.RawMessage
+				}
+			}
+		}
+		if err := json.Unmarshal([]byte(body), &data); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		for _, rs := range data.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans = append(spans, span)
+				}
+			}
+		}
+		return spans
+	}
+	var data tracepb.TracesData
+	if err := proto.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("proto.Unmarshal() error = %v", err)
+	}
+	for _, rs := range data.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				b, err := proto.Marshal(span)
+				if err != nil {
+					t.Fatalf("proto.Marshal() error = %v", err)
+				}
+				spans = append(spans, b)
+			}
+		}
+	}
+	return spans
+}
+
+func TestExporterOTLPBatchLimit(t *testing.T) {
+	t.Parallel()
+	// a resource larger than the spans
+	res := resource.NewSchemaless(attribute.String("host.description", strings.Repeat("x", 300)))
+	stubs := make(tracetest.SpanStubs, 20)
+	for i := range stubs {
+		stubs[i] = tracetest.SpanStub{Name: fmt.Sprintf("balagan-%d", i), Resource: res}
+	}
+	for _, format := range []ExportFormat{OTLPProtobuf, OTLPJSON} {
+		exp, mm := newTestExporter(t, WithExportFormat(format), WithBatchByteLimit(1000))
+		if err := exp.ExportSpans(context.Background(), stubs.Snapshots()); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+		requests := mm.requests()
+		if len(requests) < 2 {
+			t.Fatalf("format %d: expected several requests, got %d", format, len(requests))
+		}
+		spans := 0
+		for i, body := range requests {
+			if len(body) > 1000 {
+				t.Errorf("format %d: expected requests within 1000 bytes, got %d", format, len(body))
+			}
+			encoded := otlpRequestSpans(t, format, body)
+			spans += len(encoded)
+			// the resource and the scope are sent once per request, each span only adds itself
+			if i > 0 && len(requests[i-1])+len(encoded[0])+2 <= 1000 {
+				t.Errorf("format %d: expected request %d of %d bytes to be filled up to the limit", format, i-1, len(requests[i-1]))
+			}
+		}
+		if spans != 20 {
+			t.Errorf("format %d: expected 20 spans, got %d", format, spans)
+		}
+	}
+}
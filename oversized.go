@@ -0,0 +1,152 @@
+package metis
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// TruncationMarker ends the attribute values truncated to fit a span in the batch byte limit.
+const TruncationMarker = "...[truncated]"
+
+// DefaultTruncatedAttributes are the attributes truncated when a span alone exceeds the batch
+// byte limit. A trailing * matches every attribute starting with the prefix.
+var DefaultTruncatedAttributes = []string{
+	"db.statement",
+	"http.url",
+	"http.target",
+	"url.full",
+	"http.request.header.*",
+	"http.response.header.*",
+}
+
+// truncatedSpan is a span with truncated attributes.
+type truncatedSpan struct {
+	trace.ReadOnlySpan
+	attrs []attribute.KeyValue
+}
+
+func (s truncatedSpan) Attributes() []attribute.KeyValue {
+	return s.attrs
+}
+
+// fitSpan returns the encoding of a span fitting in a batch on its own, truncating the longest
+// truncatable attribute values until it fits. It returns nil when the span doesn't fit once
+// every truncatable value is truncated, the span is then dropped.
+func (m *Exporter) fitSpan(encoder payloadEncoder, span trace.ReadOnlySpan, encoded []byte) ([]byte, error) {
+	excess, err := m.spanExcess(encoded)
+	if err != nil || excess <= 0 {
+		return encoded, err
+	}
+	attrs := append([]attribute.KeyValue(nil), span.Attributes()...)
+	for excess > 0 {
+		i, j := longestTruncatable(attrs, m.truncatedAttributes)
+		if i < 0 {
+			m.stats.addOversized(1)
+			return nil, nil
+		}
+		cut := excess
+		if m.compressedLimit && cut < attributeString(attrs[i], j)/2 {
+			// the excess is measured compressed, cut faster
+			cut = attributeString(attrs[i], j) / 2
+		}
+		attrs[i] = truncateAttribute(attrs[i], j, cut)
+		encoded, err = encoder.encode(truncatedSpan{ReadOnlySpan: span, attrs: attrs})
+		if err != nil {
+			return nil, err
+		}
+		excess, err = m.spanExcess(encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.stats.addTruncated(1)
+	return encoded, nil
+}
+
+// spanExcess returns the number of bytes a batch holding only the encoded span exceeds the
+// batch byte limit with.
+func (m *Exporter) spanExcess(encoded []byte) (int, error) {
+	// brackets of the batch
+	size := len(encoded) + 2
+	if m.compressedLimit && size > m.batchByteLimit {
+		compressed, err := m.groupSize([][]byte{encoded})
+		if err != nil {
+			return 0, err
+		}
+		size = compressed + 2
+	}
+	return size - m.batchByteLimit, nil
+}
+
+// longestTruncatable returns the index of the attribute holding the longest truncatable
+// string and its index in the attribute value when it is a slice, -1 if there is none.
+func longestTruncatable(attrs []attribute.KeyValue, patterns []string) (int, int) {
+	longest, index, elem := len(TruncationMarker), -1, -1
+	for i, attr := range attrs {
+		if !matchesAttribute(patterns, attr.Key) {
+			continue
+		}
+		switch attr.Value.Type() {
+		case attribute.STRING:
+			if n := len(attr.Value.AsString()); n > longest {
+				longest, index, elem = n, i, -1
+			}
+		case attribute.STRINGSLICE:
+			for j, s := range attr.Value.AsStringSlice() {
+				if n := len(s); n > longest {
+					longest, index, elem = n, i, j
+				}
+			}
+		}
+	}
+	return index, elem
+}
+
+// attributeString returns the length of the string of attr, or of its j-th element for a slice.
+func attributeString(attr attribute.KeyValue, j int) int {
+	if j < 0 {
+		return len(attr.Value.AsString())
+	}
+	return len(attr.Value.AsStringSlice()[j])
+}
+
+// truncateAttribute shortens the string of attr, or its j-th element for a slice, by at least
+// cut bytes, marker included.
+func truncateAttribute(attr attribute.KeyValue, j int, cut int) attribute.KeyValue {
+	if j < 0 {
+		return attr.Key.String(truncateString(attr.Value.AsString(), cut))
+	}
+	values := attr.Value.AsStringSlice()
+	values[j] = truncateString(values[j], cut)
+	return attr.Key.StringSlice(values)
+}
+
+func truncateString(s string, cut int) string {
+	n := len(s) - cut - len(TruncationMarker)
+	if n <= 0 {
+		return TruncationMarker
+	}
+	// don't split a rune
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + TruncationMarker
+}
+
+// matchesAttribute reports whether key matches one of patterns, a pattern ending with * matches
+// the keys starting with its prefix.
+func matchesAttribute(patterns []string, key attribute.Key) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(string(key), strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if string(key) == pattern {
+			return true
+		}
+	}
+	return false
+}
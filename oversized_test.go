@@ -0,0 +1,137 @@
+package metis
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newAttributeSpan(name string, attrs ...attribute.KeyValue) trace.ReadOnlySpan {
+	return tracetest.SpanStubs{{Name: name, Attributes: attrs}}.Snapshots()[0]
+}
+
+// exportedAttribute returns the value of the attribute key of the first span of a request.
+func exportedAttribute(t *testing.T, request string, key attribute.Key) any {
+	var spans []struct {
+		Attributes []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	if err := json.Unmarshal([]byte(request), &spans); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == string(key) {
+			return attr.Value.Value
+		}
+	}
+	return nil
+}
+
+func TestOversizedSpanTruncated(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithBatchByteLimit(2000))
+	span := newAttributeSpan("bulk insert",
+		attribute.String("db.statement", "INSERT INTO t VALUES "+strings.Repeat("(1, 'é'), ", 500)),
+		attribute.String("db.system", "postgresql"))
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{span}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(got))
+	}
+	if len(got[0]) > 2000 {
+		t.Errorf("expected the batch within 2000 bytes, got %d", len(got[0]))
+	}
+	statement, _ := exportedAttribute(t, got[0], "db.statement").(string)
+	if !strings.HasPrefix(statement, "INSERT INTO t VALUES") || !strings.HasSuffix(statement, TruncationMarker) ||
+		!utf8.ValidString(statement) {
+		t.Errorf("expected a truncated statement, got %q", statement)
+	}
+	if system := exportedAttribute(t, got[0], "db.system"); system != "postgresql" {
+		t.Errorf("expected other attributes to be kept, got %v", system)
+	}
+	if stats := exp.Stats(); stats.SpansTruncated != 1 || stats.Dropped.Oversized != 0 {
+		t.Errorf("expected 1 truncated span, got %+v", stats)
+	}
+}
+
+func TestOversizedSpanHeadersTruncated(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithBatchByteLimit(1000))
+	span := newAttributeSpan("GET /",
+		attribute.StringSlice("http.request.header.cookie", []string{"a=b", strings.Repeat("c", 2000)}))
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{span}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 1 || len(got[0]) > 1000 {
+		t.Fatalf("expected 1 request within the limit, got %v", got)
+	}
+	cookies, _ := exportedAttribute(t, got[0], "http.request.header.cookie").([]any)
+	if len(cookies) != 2 || cookies[0] != "a=b" || !strings.HasSuffix(cookies[1].(string), TruncationMarker) {
+		t.Errorf("expected the long header value to be truncated, got %v", cookies)
+	}
+}
+
+func TestOversizedSpanDropped(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithBatchByteLimit(2000), WithTruncatedAttributes())
+	oversized := newAttributeSpan("oversized", attribute.String("db.statement", strings.Repeat("x", 4000)))
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{oversized}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 0 {
+		t.Fatalf("expected no request, got %v", got)
+	}
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{
+		newAttributeSpan("before"), oversized, newAttributeSpan("after"),
+	}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 1 || !strings.Contains(got[0], "before") || !strings.Contains(got[0], "after") ||
+		strings.Contains(got[0], "oversized") {
+		t.Errorf("expected the spans around the oversized one in 1 request, got %v", got)
+	}
+	if dropped := exp.Stats().Dropped.Oversized; dropped != 2 {
+		t.Errorf("expected 2 oversized spans dropped, got %d", dropped)
+	}
+}
+
+func TestOversizedSpanCompressedLimit(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithBatchByteLimit(1000), WithCompression(GzipCompression), WithCompressedBatchLimit())
+	// hard to compress
+	statement := make([]byte, 0, 8000)
+	for i := 0; len(statement) < cap(statement); i++ {
+		statement = append(statement, newIdempotencyKey()...)
+	}
+	span := newAttributeSpan("query", attribute.String("db.statement", string(statement)))
+	if err := exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{span}); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 1 || !strings.Contains(got[0], TruncationMarker) {
+		t.Fatalf("expected 1 request with a truncated span, got %d", len(got))
+	}
+	if sent := exp.Stats().BytesSent; sent > 1000 {
+		t.Errorf("expected the compressed batch within 1000 bytes, got %d", sent)
+	}
+}
+
+func TestInvalidBatchByteLimit(t *testing.T) {
+	t.Parallel()
+	for _, limit := range []int{0, -1} {
+		if _, err := NewExporter(WithAPIKey("test-api-key"), WithBatchByteLimit(limit)); err == nil {
+			t.Errorf("expected an error for a batch byte limit of %d", limit)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package metis
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PayloadSchemaVersion is the version of the Metis JSON payload, a JSON array of PayloadSpan.
+// It is sent in the X-Metis-Schema-Version header of MetisJSON requests and changes with
+// any change of the payload layout.
+const PayloadSchemaVersion = "1"
+
+// schemaVersionHeader carries PayloadSchemaVersion.
+const schemaVersionHeader = "X-Metis-Schema-Version"
+
+// PayloadSpan is a span of the Metis JSON payload.
+type PayloadSpan struct {
+	Name              string             `json:"Name"`
+	SpanContext       PayloadSpanContext `json:"SpanContext"`
+	Parent            PayloadSpanContext `json:"Parent"`
+	SpanKind          int                `json:"SpanKind"`
+	StartTime         time.Time          `json:"StartTime"`
+	EndTime           time.Time          `json:"EndTime"`
+	Attributes        []PayloadAttribute `json:"Attributes"`
+	Events            []PayloadEvent     `json:"Events"`
+	Links             []PayloadLink      `json:"Links"`
+	Status            PayloadStatus      `json:"Status"`
+	DroppedAttributes int                `json:"DroppedAttributes"`
+	DroppedEvents     int                `json:"DroppedEvents"`
+	DroppedLinks      int                `json:"DroppedLinks"`
+	ChildSpanCount    int                `json:"ChildSpanCount"`
+	// Resource is null for spans without a resource.
+	Resource               []PayloadAttribute `json:"Resource"`
+	InstrumentationLibrary PayloadScope       `json:"InstrumentationLibrary"`
+}
+
+// PayloadSpanContext identifies a span, ids and flags are hex encoded.
+type PayloadSpanContext struct {
+	TraceID    string `json:"TraceID"`
+	SpanID     string `json:"SpanID"`
+	TraceFlags string `json:"TraceFlags"`
+	TraceState string `json:"TraceState"`
+	Remote     bool   `json:"Remote"`
+}
+
+// PayloadAttribute is a span, event, link or resource attribute.
+type PayloadAttribute struct {
+	Key   string       `json:"Key"`
+	Value PayloadValue `json:"Value"`
+}
+
+// PayloadValue is an attribute value. Type is the name of an attribute.Type, such as STRING
+// or INT64SLICE, Value is the JSON encoding of the value.
+type PayloadValue struct {
+	Type  string          `json:"Type"`
+	Value json.RawMessage `json:"Value"`
+}
+
+// AttributeValue decodes v.
+func (v PayloadValue) AttributeValue() (attribute.Value, error) {
+	var err error
+	switch v.Type {
+	case attribute.BOOL.String():
+		var b bool
+		err = json.Unmarshal(v.Value, &b)
+		return attribute.BoolValue(b), err
+	case attribute.INT64.String():
+		var i int64
+		err = json.Unmarshal(v.Value, &i)
+		return attribute.Int64Value(i), err
+	case attribute.FLOAT64.String():
+		var f float64
+		err = json.Unmarshal(v.Value, &f)
+		return attribute.Float64Value(f), err
+	case attribute.STRING.String():
+		var s string
+		err = json.Unmarshal(v.Value, &s)
+		return attribute.StringValue(s), err
+	case attribute.BOOLSLICE.String():
+		var b []bool
+		err = json.Unmarshal(v.Value, &b)
+		return attribute.BoolSliceValue(b), err
+	case attribute.INT64SLICE.String():
+		var i []int64
+		err = json.Unmarshal(v.Value, &i)
+		return attribute.Int64SliceValue(i), err
+	case attribute.FLOAT64SLICE.String():
+		var f []float64
+		err = json.Unmarshal(v.Value, &f)
+		return attribute.Float64SliceValue(f), err
+	case attribute.STRINGSLICE.String():
+		var s []string
+		err = json.Unmarshal(v.Value, &s)
+		return attribute.StringSliceValue(s), err
+	}
+	return attribute.Value{}, fmt.Errorf("unknown attribute type: %s", v.Type)
+}
+
+// PayloadEvent is a span event.
+type PayloadEvent struct {
+	Name                  string             `json:"Name"`
+	Attributes            []PayloadAttribute `json:"Attributes"`
+	DroppedAttributeCount int                `json:"DroppedAttributeCount"`
+	Time                  time.Time          `json:"Time"`
+}
+
+// PayloadLink is a span link.
+type PayloadLink struct {
+	SpanContext           PayloadSpanContext `json:"SpanContext"`
+	Attributes            []PayloadAttribute `json:"Attributes"`
+	DroppedAttributeCount int                `json:"DroppedAttributeCount"`
+}
+
+// PayloadStatus is a span status, Code is Unset, Error or Ok.
+type PayloadStatus struct {
+	Code        string `json:"Code"`
+	Description string `json:"Description"`
+}
+
+// PayloadScope is the instrumentation scope of a span.
+type PayloadScope struct {
+	Name      string `json:"Name"`
+	Version   string `json:"Version"`
+	SchemaURL string `json:"SchemaURL"`
+}
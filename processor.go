@@ -0,0 +1,83 @@
+package metis
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// NewSpanProcessor returns a batch span processor exporting to Metis, to be registered
+// on your own tracer provider next to other span processors:
+//
+//	sp, err := metis.NewSpanProcessor()
+//	tp := trace.NewTracerProvider(trace.WithSpanProcessor(sp), trace.WithBatcher(otherExporter))
+//
+// It accepts the same options and environment variables as NewTracerProvider. Unlike
+// NewTracerProvider it leaves the global propagator and the resource to the caller.
+func NewSpanProcessor(opts ...Option) (trace.SpanProcessor, error) {
+	return newSpanProcessor(newConfig(append(envOptions(), opts...)))
+}
+
+func newSpanProcessor(cfg *config) (trace.SpanProcessor, error) {
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	batchOptions := cfg.batchOptions
+	if cfg.maxExportDelay > 0 {
+		batchOptions = append(batchOptions[:len(batchOptions):len(batchOptions)], trace.WithBatchTimeout(cfg.maxExportDelay))
+	}
+	return &spanProcessor{
+		SpanProcessor: trace.NewBatchSpanProcessor(reportingExporter{exporter}, batchOptions...),
+		exporter:      exporter,
+	}, nil
+}
+
+// spanProcessor is a batch span processor that also shows started spans to the exporter filter.
+type spanProcessor struct {
+	trace.SpanProcessor
+	exporter *Exporter
+}
+
+func (sp *spanProcessor) OnStart(parent context.Context, s trace.ReadWriteSpan) {
+	if o, ok := sp.exporter.filter.(startObserver); ok {
+		o.OnStart(s)
+	}
+	sp.SpanProcessor.OnStart(parent, s)
+}
+
+// Shutdown stops the batch span processor, then the exporter. The batch span processor gives up
+// at the deadline of ctx without stopping its last upload, the exporter abandons it.
+func (sp *spanProcessor) Shutdown(ctx context.Context) error {
+	err := sp.SpanProcessor.Shutdown(ctx)
+	if shutdownErr := sp.exporter.Shutdown(ctx); shutdownErr != nil {
+		return shutdownErr
+	}
+	return err
+}
+
+// ForceFlush exports the spans waiting in the batch span processor, then flushes the exporter.
+func (sp *spanProcessor) ForceFlush(ctx context.Context) error {
+	if err := sp.SpanProcessor.ForceFlush(ctx); err != nil {
+		return err
+	}
+	return sp.exporter.ForceFlush(ctx)
+}
+
+// reportingExporter reports the export errors to the error handler instead of returning them,
+// the batch span processor would report them a second time through otel.Handle. The exporter
+// is shut down by spanProcessor.
+type reportingExporter struct {
+	*Exporter
+}
+
+func (e reportingExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func (e reportingExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	if err := e.Exporter.ExportSpans(ctx, spans); err != nil {
+		e.errorHandler.Handle(err)
+	}
+	return nil
+}
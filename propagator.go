@@ -0,0 +1,61 @@
+package metis
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// propagatorsEnv lists the propagators installed by NewTracerProvider, comma separated.
+const propagatorsEnv = "OTEL_PROPAGATORS"
+
+// newPropagator returns the global propagator installed by NewTracerProvider: the one of
+// WithPropagators, else the one of OTEL_PROPAGATORS, else TraceContext.
+func newPropagator(cfg *config) (propagation.TextMapPropagator, error) {
+	if cfg.propagators != nil {
+		return parsePropagators(cfg.propagators)
+	}
+	names := os.Getenv(propagatorsEnv)
+	if names == "" {
+		return propagation.TraceContext{}, nil
+	}
+	// unknown names of the environment are ignored, as the specification requires
+	propagator, err := parsePropagators(strings.Split(names, ","))
+	if err != nil {
+		cfg.errorHandler.Handle(fmt.Errorf("%w, set in %s", err, propagatorsEnv))
+	}
+	return propagator, nil
+}
+
+// parsePropagators returns the composite of the named propagators, "none" disables propagation.
+// Unknown names are left out of the returned propagator and reported in the error.
+func parsePropagators(names []string) (propagation.TextMapPropagator, error) {
+	var propagators []propagation.TextMapPropagator
+	var unknown []string
+	for _, name := range names {
+		switch name = strings.TrimSpace(name); strings.ToLower(name) {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case "jaeger":
+			propagators = append(propagators, jaeger.Jaeger{})
+		case "none", "":
+		default:
+			unknown = append(unknown, name)
+		}
+	}
+	propagator := propagation.NewCompositeTextMapPropagator(propagators...)
+	if len(unknown) > 0 {
+		return propagator, fmt.Errorf("metis: unknown propagators: %s", strings.Join(unknown, ", "))
+	}
+	return propagator, nil
+}
@@ -0,0 +1,90 @@
+package metis
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var testSpanContext = oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+	TraceID:    oteltrace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     oteltrace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: oteltrace.FlagsSampled,
+})
+
+// injectedHeaders returns the sorted headers injected by propagator for testSpanContext.
+func injectedHeaders(propagator propagation.TextMapPropagator) []string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(oteltrace.ContextWithSpanContext(context.Background(), testSpanContext), carrier)
+	headers := carrier.Keys()
+	sort.Strings(headers)
+	return headers
+}
+
+func TestWithPropagators(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{names: []string{"b3"}, want: []string{"b3"}},
+		{names: []string{"b3multi"}, want: []string{"x-b3-sampled", "x-b3-spanid", "x-b3-traceid"}},
+		{names: []string{"jaeger"}, want: []string{"uber-trace-id"}},
+		{names: []string{"tracecontext", "b3"}, want: []string{"b3", "traceparent"}},
+		{names: []string{"none"}},
+	}
+	for _, tt := range tests {
+		// the option takes precedence over the environment
+		t.Setenv("OTEL_PROPAGATORS", "baggage")
+		newTestTracerProvider(t, WithPropagators(tt.names...))
+		if got := injectedHeaders(otel.GetTextMapPropagator()); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("WithPropagators(%v) injected %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestWithPropagatorsUnknown(t *testing.T) {
+	t.Parallel()
+	if _, err := NewTracerProvider(WithAPIKey("test-api-key"), WithPropagators("tracecontext", "xray")); err == nil || !strings.Contains(err.Error(), "xray") {
+		t.Errorf("expected an unknown propagator error, got %v", err)
+	}
+}
+
+func TestWithoutGlobalPropagator(t *testing.T) {
+	propagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagator) })
+	otel.SetTextMapPropagator(propagation.Baggage{})
+	newTestTracerProvider(t, WithoutGlobalPropagator(), WithPropagators("b3"))
+	if got := otel.GetTextMapPropagator().Fields(); len(got) != 1 || got[0] != "baggage" {
+		t.Errorf("expected the global propagator to be left untouched, got fields %v", got)
+	}
+}
+
+func TestParsePropagators(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		names      string
+		wantFields []string
+		wantErr    bool
+	}{
+		{names: "tracecontext", wantFields: []string{"traceparent", "tracestate"}},
+		{names: "tracecontext, Baggage", wantFields: []string{"baggage", "traceparent", "tracestate"}},
+		{names: "none"},
+		{names: "tracecontext,ottrace", wantFields: []string{"traceparent", "tracestate"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		propagator, err := parsePropagators(strings.Split(tt.names, ","))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePropagators(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+		}
+		fields := propagator.Fields()
+		sort.Strings(fields)
+		if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+			t.Errorf("parsePropagators(%q) fields = %v, want %v", tt.names, fields, tt.wantFields)
+		}
+	}
+}
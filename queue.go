@@ -0,0 +1,220 @@
+package metis
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what happens to a batch when the export queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest drops the oldest queued batches to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest drops the new batch.
+	DropNewest
+	// Block waits for room in the queue up to ExportQueueConfig.BlockTimeout, then drops the new batch.
+	Block
+)
+
+// ExportQueueConfig configures the queue batches wait in before they are uploaded.
+type ExportQueueConfig struct {
+	// MaxSpans is the maximum number of queued spans.
+	MaxSpans int
+	// MaxBytes is the maximum size of the queued spans, before compression.
+	MaxBytes int
+	// Overflow is applied when a batch doesn't fit in the queue.
+	// A batch is always accepted by an empty queue, whatever its size.
+	Overflow OverflowPolicy
+	// BlockTimeout is the maximum time an export waits for room with the Block policy.
+	BlockTimeout time.Duration
+	// Workers is the number of batches uploaded in parallel.
+	Workers int
+}
+
+// DefaultExportQueueConfig is the export queue configuration used for zero fields.
+var DefaultExportQueueConfig = ExportQueueConfig{
+	MaxSpans:     20000,
+	MaxBytes:     32 << 20, // 32MB
+	Overflow:     DropOldest,
+	BlockTimeout: 5 * time.Second,
+	Workers:      4,
+}
+
+// queuedBatch is a batch of encoded spans waiting to be uploaded.
+type queuedBatch struct {
+	spans [][]byte
+	size  int
+}
+
+// exportQueue is a FIFO of batches bounded in spans and bytes.
+type exportQueue struct {
+	cfg ExportQueueConfig
+
+	mu      sync.Mutex
+	batches []queuedBatch
+	spans   int
+	size    int
+	closed  bool
+	// busy is the number of batches being uploaded.
+	busy int
+	// changed is closed and replaced whenever batches are added or removed.
+	changed chan struct{}
+}
+
+func newExportQueue(cfg ExportQueueConfig) *exportQueue {
+	if cfg.MaxSpans <= 0 {
+		cfg.MaxSpans = DefaultExportQueueConfig.MaxSpans
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultExportQueueConfig.MaxBytes
+	}
+	if cfg.BlockTimeout <= 0 {
+		cfg.BlockTimeout = DefaultExportQueueConfig.BlockTimeout
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultExportQueueConfig.Workers
+	}
+	return &exportQueue{cfg: cfg, changed: make(chan struct{})}
+}
+
+// notify wakes up the goroutines waiting for a change, q.mu must be held.
+func (q *exportQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// fits reports whether b can be queued, q.mu must be held.
+func (q *exportQueue) fits(b queuedBatch) bool {
+	return len(q.batches) == 0 ||
+		(q.spans+len(b.spans) <= q.cfg.MaxSpans && q.size+b.size <= q.cfg.MaxBytes)
+}
+
+// push queues spans according to the overflow policy and returns the number of dropped spans.
+func (q *exportQueue) push(ctx context.Context, spans [][]byte) int {
+	b := queuedBatch{spans: spans}
+	for _, span := range spans {
+		b.size += len(span)
+	}
+	var timeout <-chan time.Time
+	dropped := 0
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return dropped + len(b.spans)
+		}
+		if q.fits(b) {
+			break
+		}
+		switch q.cfg.Overflow {
+		case DropNewest:
+			q.mu.Unlock()
+			return len(b.spans)
+		case DropOldest:
+			oldest := q.batches[0]
+			q.batches = q.batches[1:]
+			q.spans -= len(oldest.spans)
+			q.size -= oldest.size
+			dropped += len(oldest.spans)
+		default:
+			if timeout == nil {
+				timer := time.NewTimer(q.cfg.BlockTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+			changed := q.changed
+			q.mu.Unlock()
+			select {
+			case <-changed:
+			case <-timeout:
+				return len(b.spans)
+			case <-ctx.Done():
+				return len(b.spans)
+			}
+			q.mu.Lock()
+		}
+	}
+	q.batches = append(q.batches, b)
+	q.spans += len(b.spans)
+	q.size += b.size
+	q.notify()
+	q.mu.Unlock()
+	return dropped
+}
+
+// pop waits for the next batch, it returns false once the queue is closed and empty.
+func (q *exportQueue) pop() (queuedBatch, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.batches) == 0 {
+		if q.closed {
+			return queuedBatch{}, false
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		<-changed
+		q.mu.Lock()
+	}
+	b := q.batches[0]
+	q.batches[0] = queuedBatch{}
+	q.batches = q.batches[1:]
+	q.spans -= len(b.spans)
+	q.size -= b.size
+	q.busy++
+	q.notify()
+	return b, true
+}
+
+// done reports the upload of a popped batch is over.
+func (q *exportQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.busy--
+	q.notify()
+}
+
+// waitIdle waits until the queue is empty and no batch is being uploaded, or ctx is done.
+func (q *exportQueue) waitIdle(ctx context.Context) error {
+	q.mu.Lock()
+	for len(q.batches) > 0 || q.busy > 0 {
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		q.mu.Lock()
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// len returns the number of queued spans.
+func (q *exportQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.spans
+}
+
+// close stops accepting batches, the queued ones are still returned by pop.
+func (q *exportQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notify()
+}
+
+// discard empties the queue and returns the number of discarded spans.
+func (q *exportQueue) discard() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	spans := q.spans
+	q.batches = nil
+	q.spans = 0
+	q.size = 0
+	q.notify()
+	return spans
+}
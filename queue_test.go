@@ -0,0 +1,140 @@
+package metis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func namedSpan(name string) []trace.ReadOnlySpan {
+	return tracetest.SpanStubs{{Name: name}}.Snapshots()
+}
+
+// exportNamed exports a span per name, each in its own batch.
+func exportNamed(t *testing.T, exp *Exporter, names ...string) {
+	for _, name := range names {
+		if err := exp.ExportSpans(context.Background(), namedSpan(name)); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+	}
+}
+
+func TestExportQueueOverflow(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		want     []string
+	}{
+		{name: "drop newest", overflow: DropNewest, want: []string{"first", "second"}},
+		{name: "drop oldest", overflow: DropOldest, want: []string{"first", "third"}},
+		{name: "block timeout", overflow: Block, want: []string{"first", "second"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			exp, mm := newTestExporter(t, WithExportQueue(ExportQueueConfig{
+				MaxSpans:     1,
+				Overflow:     tt.overflow,
+				BlockTimeout: 10 * time.Millisecond,
+				Workers:      1,
+			}))
+			received, release := mm.block()
+			exportNamed(t, exp, "first")
+			<-received // the worker is busy uploading the first batch
+			exportNamed(t, exp, "second", "third")
+			if got := exp.Stats().Dropped.QueueFull; got != 1 {
+				t.Errorf("expected 1 dropped span, got %d", got)
+			}
+			close(release)
+			if err := exp.Shutdown(context.Background()); err != nil {
+				t.Fatalf("Shutdown() error = %v", err)
+			}
+			got := mm.requests()
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d requests, got %v", len(tt.want), got)
+			}
+			for i, name := range tt.want {
+				if !strings.Contains(got[i], name) {
+					t.Errorf("expected request %d to contain %s, got %s", i, name, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExportQueueBlockWaitsForRoom(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithExportQueue(ExportQueueConfig{
+		MaxSpans:     1,
+		Overflow:     Block,
+		BlockTimeout: time.Minute,
+		Workers:      1,
+	}))
+	received, release := mm.block()
+	exportNamed(t, exp, "first")
+	<-received
+	exportNamed(t, exp, "second")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	exportNamed(t, exp, "third")
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 3 || exp.Stats().Dropped.QueueFull != 0 {
+		t.Errorf("expected 3 requests and no dropped span, got %v, %+v", got, exp.Stats().Dropped)
+	}
+}
+
+func TestExportQueueParallelWorkers(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithExportQueue(ExportQueueConfig{Workers: 3}))
+	received, release := mm.block()
+	exportNamed(t, exp, "first", "second", "third")
+	// ExportSpans doesn't wait for the uploads, all of them are in flight at once
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 parallel uploads, got %d", i)
+		}
+	}
+	close(release)
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if got := mm.requests(); len(got) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(got))
+	}
+}
+
+func TestExportQueueShutdownDeadline(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithExportQueue(ExportQueueConfig{Workers: 1}))
+	received, release := mm.block()
+	defer close(release)
+	exportNamed(t, exp, "first")
+	<-received
+	exportNamed(t, exp, "second")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// the upload in flight and the queued batch are both abandoned
+	start := time.Now()
+	if err := exp.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "abandoned 2 spans") {
+		t.Errorf("expected 2 abandoned spans, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Shutdown to return at its deadline, took %v", elapsed)
+	}
+	if got := exp.Stats().Dropped.Abandoned; got != 2 {
+		t.Errorf("expected 2 abandoned spans, got %d", got)
+	}
+}
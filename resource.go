@@ -0,0 +1,118 @@
+package metis
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	// vcsRevisionKey and vcsModifiedKey carry the VCS settings stamped by go build.
+	vcsRevisionKey = attribute.Key("vcs.revision")
+	vcsModifiedKey = attribute.Key("vcs.modified")
+)
+
+// newResource returns a resource describing this application. Later sources take precedence:
+//  1. resource.Default and the metis-go-client service name
+//  2. host, OS, process and container detection
+//  3. the module version and VCS revision of the build
+//  4. the detectors of WithResourceDetectors
+//  5. OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME
+//  6. the service and environment options, and their METIS_* environment variables
+//
+// The resource of WithResource replaces the first five sources. Detection errors are reported
+// to the error handler, the resource keeps what was detected.
+func newResource(ctx context.Context, cfg *config) *resource.Resource {
+	res := cfg.resource
+	if res == nil {
+		res = detectResource(ctx, cfg)
+	}
+	var attrs []attribute.KeyValue
+	if cfg.serviceName != "" {
+		attrs = append(attrs, semconv.ServiceName(cfg.serviceName))
+	}
+	if cfg.serviceVersion != "" {
+		attrs = append(attrs, semconv.ServiceVersion(cfg.serviceVersion))
+	}
+	if cfg.environment != "" {
+		attrs = append(attrs, semconv.DeploymentEnvironment(cfg.environment))
+	}
+	return mergeResources(res, resource.NewSchemaless(attrs...))
+}
+
+// detectResource merges the first five sources of newResource.
+func detectResource(ctx context.Context, cfg *config) *resource.Resource {
+	res := mergeResources(resource.Default(), resource.NewSchemaless(semconv.ServiceName(defaultServiceName)))
+	detected, err := resource.New(ctx,
+		resource.WithHost(),
+		resource.WithOSType(),
+		resource.WithProcessPID(),
+		resource.WithProcessExecutableName(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithProcessRuntimeDescription(),
+		resource.WithContainer(),
+	)
+	res = mergeDetected(cfg, res, detected, err)
+	detectors := append([]resource.Detector{buildInfoDetector{readBuildInfo: debug.ReadBuildInfo}}, cfg.resourceDetectors...)
+	for _, detector := range detectors {
+		if detector == nil {
+			continue
+		}
+		detected, err := detector.Detect(ctx)
+		res = mergeDetected(cfg, res, detected, err)
+	}
+	detected, err = resource.New(ctx, resource.WithFromEnv())
+	return mergeDetected(cfg, res, detected, err)
+}
+
+// mergeDetected merges the result of a detection into res, a partial result is kept.
+func mergeDetected(cfg *config, res, detected *resource.Resource, err error) *resource.Resource {
+	if err != nil {
+		cfg.errorHandler.Handle(fmt.Errorf("metis: resource detection: %w", err))
+	}
+	if detected == nil {
+		return res
+	}
+	return mergeResources(res, detected)
+}
+
+// mergeResources merges b into a, b wins. Unlike resource.Merge, resources of different
+// schema URLs are merged without a schema URL instead of failing.
+func mergeResources(a, b *resource.Resource) *resource.Resource {
+	merged, err := resource.Merge(a, b)
+	if err != nil {
+		merged, _ = resource.Merge(resource.NewSchemaless(a.Attributes()...), resource.NewSchemaless(b.Attributes()...))
+	}
+	return merged
+}
+
+// buildInfoDetector detects the version and the VCS revision of the main module.
+type buildInfoDetector struct {
+	readBuildInfo func() (*debug.BuildInfo, bool)
+}
+
+func (d buildInfoDetector) Detect(context.Context) (*resource.Resource, error) {
+	info, ok := d.readBuildInfo()
+	if !ok {
+		return resource.Empty(), nil
+	}
+	var attrs []attribute.KeyValue
+	// go run and go test builds have no version
+	if version := info.Main.Version; version != "" && version != "(devel)" {
+		attrs = append(attrs, semconv.ServiceVersion(version))
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			attrs = append(attrs, vcsRevisionKey.String(setting.Value))
+		case "vcs.modified":
+			attrs = append(attrs, vcsModifiedKey.Bool(setting.Value == "true"))
+		}
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
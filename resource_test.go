@@ -0,0 +1,159 @@
+package metis
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func resourceValue(res *resource.Resource, key attribute.Key) (string, bool) {
+	value, ok := res.Set().Value(key)
+	return value.Emit(), ok
+}
+
+func TestNewResource(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "team=core,deployment.environment=dev")
+	t.Setenv("OTEL_SERVICE_NAME", "otel-service")
+	t.Setenv("METIS_ENVIRONMENT", "staging")
+	detector := detectorFunc(func(context.Context) (*resource.Resource, error) {
+		return resource.NewSchemaless(attribute.String("cloud.region", "eu-central-1"), attribute.String("team", "detected")), nil
+	})
+	res := newResource(context.Background(), newConfig(append(envOptions(), WithResourceDetectors(detector))))
+
+	tests := []struct {
+		key  attribute.Key
+		want string
+	}{
+		// OTEL_SERVICE_NAME applies without a Metis service name
+		{key: semconv.ServiceNameKey, want: "otel-service"},
+		// METIS_ENVIRONMENT wins over OTEL_RESOURCE_ATTRIBUTES
+		{key: semconv.DeploymentEnvironmentKey, want: "staging"},
+		// OTEL_RESOURCE_ATTRIBUTES wins over the detectors
+		{key: "team", want: "core"},
+		{key: "cloud.region", want: "eu-central-1"},
+		{key: semconv.TelemetrySDKLanguageKey, want: "go"},
+	}
+	for _, tt := range tests {
+		if got, _ := resourceValue(res, tt.key); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	for _, key := range []attribute.Key{semconv.HostNameKey, semconv.ProcessPIDKey, semconv.ProcessRuntimeVersionKey, semconv.TelemetrySDKVersionKey} {
+		if _, ok := resourceValue(res, key); !ok {
+			t.Errorf("expected %s to be detected", key)
+		}
+	}
+}
+
+func TestNewResourceServiceName(t *testing.T) {
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "")
+	if got, _ := resourceValue(newResource(context.Background(), newConfig(nil)), semconv.ServiceNameKey); got != defaultServiceName {
+		t.Errorf("default service.name = %q, want %q", got, defaultServiceName)
+	}
+
+	t.Setenv("OTEL_SERVICE_NAME", "otel-service")
+	res := newResource(context.Background(), newConfig([]Option{WithServiceName("metis-service"), WithServiceVersion("v1.2.3")}))
+	if got, _ := resourceValue(res, semconv.ServiceNameKey); got != "metis-service" {
+		t.Errorf("service.name = %q, want metis-service", got)
+	}
+	if got, _ := resourceValue(res, semconv.ServiceVersionKey); got != "v1.2.3" {
+		t.Errorf("service.version = %q, want v1.2.3", got)
+	}
+}
+
+func TestNewResourceWithResource(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "team=core")
+	res := newResource(context.Background(), newConfig(append(envOptions(),
+		WithResource(resource.NewSchemaless(semconv.ServiceName("fixed"), attribute.String("team", "fixed"))),
+		WithDeploymentEnvironment("staging"),
+	)))
+	want := attribute.NewSet(
+		semconv.DeploymentEnvironment("staging"),
+		semconv.ServiceName("fixed"),
+		attribute.String("team", "fixed"),
+	)
+	if got := res.Set(); !got.Equals(&want) {
+		t.Errorf("expected the resource to replace the detected one, got %v", res.Attributes())
+	}
+}
+
+func TestNewResourceDetectorError(t *testing.T) {
+	var handled []error
+	failing := detectorFunc(func(context.Context) (*resource.Resource, error) {
+		return nil, errors.New("metadata server unreachable")
+	})
+	res := newResource(context.Background(), newConfig([]Option{
+		WithResourceDetectors(failing),
+		WithErrorHandler(ErrorHandlerFunc(func(err error) {
+			handled = append(handled, err)
+		})),
+	}))
+	reported := false
+	for _, err := range handled {
+		reported = reported || strings.Contains(err.Error(), "metadata server unreachable")
+	}
+	if !reported {
+		t.Errorf("expected the detector error to be reported, got %v", handled)
+	}
+	if _, ok := resourceValue(res, semconv.HostNameKey); !ok {
+		t.Error("expected the other attributes to be detected")
+	}
+}
+
+func TestBuildInfoDetector(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		info *debug.BuildInfo
+		want map[attribute.Key]string
+	}{
+		{
+			name: "tagged module",
+			info: &debug.BuildInfo{
+				Main: debug.Module{Path: "example.com/service", Version: "v1.4.0"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: "4f2c9e1"},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			want: map[attribute.Key]string{
+				semconv.ServiceVersionKey: "v1.4.0",
+				vcsRevisionKey:            "4f2c9e1",
+				vcsModifiedKey:            "true",
+			},
+		},
+		{
+			name: "devel build",
+			info: &debug.BuildInfo{Main: debug.Module{Path: "example.com/service", Version: "(devel)"}},
+			want: map[attribute.Key]string{},
+		},
+	}
+	for _, tt := range tests {
+		detector := buildInfoDetector{readBuildInfo: func() (*debug.BuildInfo, bool) { return tt.info, true }}
+		res, err := detector.Detect(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Detect() error = %v", tt.name, err)
+		}
+		if res.Len() != len(tt.want) {
+			t.Errorf("%s: expected %d attributes, got %v", tt.name, len(tt.want), res.Attributes())
+		}
+		for key, want := range tt.want {
+			if got, _ := resourceValue(res, key); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, key, got, want)
+			}
+		}
+	}
+}
+
+type detectorFunc func(context.Context) (*resource.Resource, error)
+
+func (f detectorFunc) Detect(ctx context.Context) (*resource.Resource, error) {
+	return f(ctx)
+}
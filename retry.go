@@ -0,0 +1,157 @@
+package metis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryConfig defines how failed span uploads are retried.
+// Uploads are retried on network errors and on 429, 502, 503 and 504 responses,
+// with a jittered exponential backoff that follows the Retry-After header when present.
+type RetryConfig struct {
+	// Enabled enables retries.
+	Enabled bool
+	// InitialInterval is the delay before the first retry.
+	InitialInterval time.Duration
+	// MaxInterval is the upper bound of the delay between retries.
+	MaxInterval time.Duration
+	// MaxElapsedTime is the maximum time spent on a single upload.
+	// Retries also stop once the export context is done.
+	MaxElapsedTime time.Duration
+}
+
+// DefaultRetryConfig is the retry configuration used unless WithRetry is set, and for the zero
+// fields of an enabled configuration.
+var DefaultRetryConfig = RetryConfig{
+	Enabled:         true,
+	InitialInterval: time.Second,
+	MaxInterval:     10 * time.Second,
+	MaxElapsedTime:  30 * time.Second,
+}
+
+// withDefaults returns rc with its zero fields set from DefaultRetryConfig.
+func (rc RetryConfig) withDefaults() RetryConfig {
+	if rc.InitialInterval <= 0 {
+		rc.InitialInterval = DefaultRetryConfig.InitialInterval
+	}
+	if rc.MaxInterval <= 0 {
+		rc.MaxInterval = DefaultRetryConfig.MaxInterval
+	}
+	if rc.MaxElapsedTime <= 0 {
+		rc.MaxElapsedTime = DefaultRetryConfig.MaxElapsedTime
+	}
+	return rc
+}
+
+// jitterFactor is the maximum relative deviation applied to a backoff interval.
+const jitterFactor = 0.5
+
+// do calls fn until it succeeds, returns a non retryable error or the retry budget is exhausted.
+func (rc RetryConfig) do(ctx context.Context, fn func(context.Context) error) error {
+	if !rc.Enabled {
+		return fn(ctx)
+	}
+	start := time.Now()
+	interval := rc.InitialInterval
+	var last error
+	for {
+		err := fn(ctx)
+		if err != nil && last != nil && ctx.Err() != nil {
+			// the deadline cut a retry short, the previous error tells why the upload failed
+			return fmt.Errorf("%v: %w", ctx.Err(), last)
+		}
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		last = err
+		delay := jitter(interval)
+		if retryAfter := retryAfterOf(err); retryAfter > 0 {
+			delay = retryAfter
+		}
+		if time.Since(start)+delay > rc.MaxElapsedTime {
+			return fmt.Errorf("max retry time elapsed: %w", err)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return fmt.Errorf("export deadline reached before next retry: %w", err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%v: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+		interval *= 2
+		if interval > rc.MaxInterval {
+			interval = rc.MaxInterval
+		}
+	}
+}
+
+func jitter(interval time.Duration) time.Duration {
+	delta := jitterFactor * float64(interval)
+	return time.Duration(float64(interval) - delta + mathrand.Float64()*2*delta)
+}
+
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var keyErr *APIKeyError
+	if errors.As(err, &keyErr) {
+		return false
+	}
+	var exportErr *ExportError
+	if !errors.As(err, &exportErr) {
+		// network errors
+		return true
+	}
+	switch exportErr.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func retryAfterOf(err error) time.Duration {
+	var exportErr *ExportError
+	if !errors.As(err, &exportErr) {
+		return 0
+	}
+	return exportErr.RetryAfter
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an http date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// newIdempotencyKey returns a random key identifying a single batch across retries.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
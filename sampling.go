@@ -0,0 +1,217 @@
+package metis
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Attributes recorded on the spans of traces kept by tail sampling.
+const (
+	// SamplingPolicyKey is the name of the policy that kept the trace.
+	SamplingPolicyKey = attribute.Key("metis.sampling.policy")
+	// SamplingProbabilityKey is the probability the trace was kept with.
+	SamplingProbabilityKey = attribute.Key("metis.sampling.probability")
+	// SamplingAdjustedCountKey is the number of traces the kept trace stands for, 1/probability.
+	SamplingAdjustedCountKey = attribute.Key("metis.sampling.adjusted_count")
+)
+
+// SamplingPolicy decides whether a complete trace is exported.
+type SamplingPolicy interface {
+	// Name identifies the policy in the SamplingPolicyKey attribute.
+	Name() string
+	// Sample reports whether the trace is kept and the probability it was kept with.
+	// spans hold the local root of the trace first, when it ended.
+	Sample(spans []trace.ReadOnlySpan) (keep bool, probability float64)
+}
+
+// TailSamplingConfig configures tail sampling. The policies of a trace are evaluated
+// in order, the first policy keeping the trace decides, traces kept by none are dropped.
+type TailSamplingConfig struct {
+	// Policies apply to traces without route policies.
+	Policies []SamplingPolicy
+	// Routes maps an http.route to the policies of its traces.
+	Routes map[string][]SamplingPolicy
+}
+
+type samplingPolicy struct {
+	name   string
+	sample func(spans []trace.ReadOnlySpan) (bool, float64)
+}
+
+func (p samplingPolicy) Name() string {
+	return p.name
+}
+
+func (p samplingPolicy) Sample(spans []trace.ReadOnlySpan) (bool, float64) {
+	return p.sample(spans)
+}
+
+// ErrorTraces keeps traces with a span whose status is Error.
+func ErrorTraces() SamplingPolicy {
+	return samplingPolicy{name: "errors", sample: func(spans []trace.ReadOnlySpan) (bool, float64) {
+		for _, span := range spans {
+			if span.Status().Code == codes.Error {
+				return true, 1
+			}
+		}
+		return false, 0
+	}}
+}
+
+// SlowTraces keeps traces lasting at least threshold, from the start of their first span
+// to the end of their last one.
+func SlowTraces(threshold time.Duration) SamplingPolicy {
+	return samplingPolicy{name: "latency", sample: func(spans []trace.ReadOnlySpan) (bool, float64) {
+		if len(spans) == 0 {
+			return false, 0
+		}
+		start, end := spans[0].StartTime(), spans[0].EndTime()
+		for _, span := range spans[1:] {
+			if span.StartTime().Before(start) {
+				start = span.StartTime()
+			}
+			if span.EndTime().After(end) {
+				end = span.EndTime()
+			}
+		}
+		return end.Sub(start) >= threshold, 1
+	}}
+}
+
+// DBQueryTraces keeps traces with more than n spans with a db.system attribute.
+func DBQueryTraces(n int) SamplingPolicy {
+	return samplingPolicy{name: "db_queries", sample: func(spans []trace.ReadOnlySpan) (bool, float64) {
+		queries := 0
+		for _, span := range spans {
+			if hasAttribute(span, semconv.DBSystemKey) {
+				queries++
+			}
+		}
+		return queries > n, 1
+	}}
+}
+
+// ProbabilisticTraces keeps a share of traces given by ratio, chosen by trace ID so every
+// process of a distributed trace takes the same decision.
+func ProbabilisticTraces(ratio float64) SamplingPolicy {
+	if ratio > 1 {
+		ratio = 1
+	}
+	if ratio < 0 {
+		ratio = 0
+	}
+	bound := uint64(ratio * (1 << 63))
+	return samplingPolicy{name: "probabilistic", sample: func(spans []trace.ReadOnlySpan) (bool, float64) {
+		if len(spans) == 0 {
+			return false, 0
+		}
+		traceID := spans[0].SpanContext().TraceID()
+		return binary.BigEndian.Uint64(traceID[8:16])>>1 < bound, ratio
+	}}
+}
+
+// samplingDecision is the outcome of tail sampling a trace, attrs are nil for dropped traces.
+type samplingDecision struct {
+	attrs []attribute.KeyValue
+}
+
+// tailSampler applies the tail sampling policies to complete traces. It is safe for concurrent use.
+type tailSampler struct {
+	cfg TailSamplingConfig
+
+	mu sync.Mutex
+	// decisions remembers the decision of released traces for their late children.
+	decisions map[oteltrace.TraceID]samplingDecision
+	order     []oteltrace.TraceID
+}
+
+func newTailSampler(cfg TailSamplingConfig) *tailSampler {
+	return &tailSampler{cfg: cfg, decisions: map[oteltrace.TraceID]samplingDecision{}}
+}
+
+// sample returns the kept traces of groups, with the sampling decision recorded on their spans.
+func (s *tailSampler) sample(groups [][]trace.ReadOnlySpan) [][]trace.ReadOnlySpan {
+	kept := groups[:0:0]
+	for _, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		decision := s.decide(group)
+		if decision.attrs == nil {
+			continue
+		}
+		annotated := make([]trace.ReadOnlySpan, len(group))
+		for i, span := range group {
+			annotated[i] = annotatedSpan{ReadOnlySpan: span, extra: decision.attrs}
+		}
+		kept = append(kept, annotated)
+	}
+	return kept
+}
+
+func (s *tailSampler) decide(group []trace.ReadOnlySpan) samplingDecision {
+	traceID := group[0].SpanContext().TraceID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if decision, ok := s.decisions[traceID]; ok {
+		return decision
+	}
+	var decision samplingDecision
+	for _, policy := range s.policies(group) {
+		keep, probability := policy.Sample(group)
+		if !keep {
+			continue
+		}
+		decision.attrs = []attribute.KeyValue{
+			SamplingPolicyKey.String(policy.Name()),
+			SamplingProbabilityKey.Float64(probability),
+		}
+		if probability > 0 {
+			decision.attrs = append(decision.attrs, SamplingAdjustedCountKey.Float64(1/probability))
+		}
+		break
+	}
+	if len(s.order) == maxTrackedTraces {
+		delete(s.decisions, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.decisions[traceID] = decision
+	s.order = append(s.order, traceID)
+	return decision
+}
+
+// policies returns the policies of the route of the trace.
+func (s *tailSampler) policies(group []trace.ReadOnlySpan) []SamplingPolicy {
+	for _, span := range group {
+		for _, attr := range span.Attributes() {
+			if attr.Key != semconv.HTTPRouteKey {
+				continue
+			}
+			if policies, ok := s.cfg.Routes[attr.Value.AsString()]; ok {
+				return policies
+			}
+			return s.cfg.Policies
+		}
+	}
+	return s.cfg.Policies
+}
+
+// annotatedSpan is a span with extra attributes.
+type annotatedSpan struct {
+	trace.ReadOnlySpan
+	extra []attribute.KeyValue
+}
+
+func (s annotatedSpan) Attributes() []attribute.KeyValue {
+	attrs := s.ReadOnlySpan.Attributes()
+	out := make([]attribute.KeyValue, 0, len(attrs)+len(s.extra))
+	out = append(out, attrs...)
+	return append(out, s.extra...)
+}
@@ -0,0 +1,169 @@
+package metis
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// newSampledTrace returns a trace t made of an http root span on route lasting d and n db spans.
+func newSampledTrace(t byte, route string, d time.Duration, status codes.Code, n int) []trace.ReadOnlySpan {
+	start := time.Date(2023, 6, 16, 10, 0, 0, 0, time.UTC)
+	stubs := tracetest.SpanStubs{{
+		Name: "GET " + route,
+		SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID: oteltrace.TraceID{15: t},
+			SpanID:  oteltrace.SpanID{1},
+		}),
+		StartTime:  start,
+		EndTime:    start.Add(d),
+		Attributes: []attribute.KeyValue{semconv.HTTPRoute(route)},
+		Status:     trace.Status{Code: status},
+	}}
+	for i := 0; i < n; i++ {
+		stubs = append(stubs, tracetest.SpanStub{
+			Name: "SELECT",
+			SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID: oteltrace.TraceID{15: t},
+				SpanID:  oteltrace.SpanID{byte(i + 2)},
+			}),
+			Parent:     stubs[0].SpanContext,
+			StartTime:  start,
+			EndTime:    start,
+			Attributes: []attribute.KeyValue{semconv.DBSystemPostgreSQL},
+		})
+	}
+	return stubs.Snapshots()
+}
+
+func TestSamplingPolicies(t *testing.T) {
+	t.Parallel()
+	fast := newSampledTrace(1, "/", time.Millisecond, codes.Unset, 1)
+	slow := newSampledTrace(2, "/", time.Second, codes.Unset, 1)
+	failed := newSampledTrace(3, "/", time.Millisecond, codes.Error, 1)
+	chatty := newSampledTrace(4, "/", time.Millisecond, codes.Unset, 5)
+	tests := []struct {
+		policy SamplingPolicy
+		trace  []trace.ReadOnlySpan
+		want   bool
+	}{
+		{ErrorTraces(), failed, true},
+		{ErrorTraces(), fast, false},
+		{SlowTraces(500 * time.Millisecond), slow, true},
+		{SlowTraces(500 * time.Millisecond), fast, false},
+		{DBQueryTraces(3), chatty, true},
+		{DBQueryTraces(3), fast, false},
+		{ProbabilisticTraces(1), fast, true},
+		{ProbabilisticTraces(0), fast, false},
+	}
+	for _, tt := range tests {
+		if keep, _ := tt.policy.Sample(tt.trace); keep != tt.want {
+			t.Errorf("%s: Sample(%s) = %v, want %v", tt.policy.Name(), tt.trace[0].Name(), keep, tt.want)
+		}
+	}
+}
+
+func TestProbabilisticTracesRatio(t *testing.T) {
+	t.Parallel()
+	policy := ProbabilisticTraces(0.25)
+	kept := 0
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		var traceID oteltrace.TraceID
+		rnd.Read(traceID[:])
+		stub := tracetest.SpanStub{SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{TraceID: traceID})}
+		if keep, p := policy.Sample([]trace.ReadOnlySpan{stub.Snapshot()}); keep {
+			kept++
+			if p != 0.25 {
+				t.Fatalf("expected probability 0.25, got %v", p)
+			}
+		}
+	}
+	if kept < 2000 || kept > 3000 {
+		t.Errorf("expected about 2500 kept traces, got %d", kept)
+	}
+}
+
+func TestExporterTailSampling(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+		WithTailSampling(TailSamplingConfig{
+			Policies: []SamplingPolicy{ErrorTraces(), SlowTraces(500 * time.Millisecond), ProbabilisticTraces(0)},
+			Routes: map[string][]SamplingPolicy{
+				"/health": {ProbabilisticTraces(0)},
+				"/orders": {DBQueryTraces(2)},
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	defer exp.Shutdown(context.Background())
+
+	var spans []trace.ReadOnlySpan
+	// children end before their root
+	for _, tr := range [][]trace.ReadOnlySpan{
+		newSampledTrace(1, "/users", time.Millisecond, codes.Unset, 1),
+		newSampledTrace(2, "/users", time.Second, codes.Unset, 1),
+		newSampledTrace(3, "/users", time.Millisecond, codes.Error, 1),
+		newSampledTrace(4, "/health", time.Second, codes.Error, 1),
+		newSampledTrace(5, "/orders", time.Millisecond, codes.Unset, 3),
+	} {
+		spans = append(spans, tr[1:]...)
+		spans = append(spans, tr[0])
+	}
+	if err := exp.ExportSpans(context.Background(), spans); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	got := mm.requests()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(got))
+	}
+	var exported []map[string]interface{}
+	if err := json.Unmarshal([]byte(got[0]), &exported); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	policies := map[string]string{}
+	for _, span := range exported {
+		traceID := span["SpanContext"].(map[string]interface{})["TraceID"].(string)
+		for _, attr := range span["Attributes"].([]interface{}) {
+			kv := attr.(map[string]interface{})
+			if kv["Key"] == string(SamplingPolicyKey) {
+				policies[traceID[30:]] = kv["Value"].(map[string]interface{})["Value"].(string)
+			}
+		}
+	}
+	want := map[string]string{"02": "latency", "03": "errors", "05": "db_queries"}
+	if len(policies) != len(want) {
+		t.Errorf("expected traces %v, got %v", want, policies)
+	}
+	for traceID, policy := range want {
+		if policies[traceID] != policy {
+			t.Errorf("trace %s: expected policy %s, got %s", traceID, policy, policies[traceID])
+		}
+	}
+	if !strings.Contains(got[0], string(SamplingAdjustedCountKey)) {
+		t.Errorf("expected the adjusted count to be recorded")
+	}
+	if n := len(exported); n != 2+2+4 {
+		t.Errorf("expected all spans of kept traces, got %d", n)
+	}
+}
@@ -0,0 +1,18 @@
+package metis
+
+import (
+	"github.com/getsentry/sentry-go"
+)
+
+// NewSentryErrorHandler returns an ErrorHandler that reports errors to Sentry.
+// A nil hub reports to the current hub. The Sentry client is not initialized by this
+// package, call sentry.Init with your own options before using the handler.
+func NewSentryErrorHandler(hub *sentry.Hub) ErrorHandler {
+	return ErrorHandlerFunc(func(err error) {
+		h := hub
+		if h == nil {
+			h = sentry.CurrentHub()
+		}
+		h.CaptureException(err)
+	})
+}
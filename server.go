@@ -0,0 +1,155 @@
+package metis
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// idempotencyKeyHeader carries a key that stays the same across retries of a batch.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxErrorBodySize is the maximum number of response body bytes kept in an ExportError.
+const maxErrorBodySize = 512
+
+const (
+	defaultConnectTimeout  = 10 * time.Second
+	defaultResponseTimeout = 30 * time.Second
+)
+
+type metisServer struct {
+	url    string
+	apiKey *apiKeyResolver
+	client *http.Client
+}
+
+// exportRequest is a batch ready to be sent.
+type exportRequest struct {
+	Body            []byte `json:"-"`
+	ContentType     string
+	ContentEncoding string
+	IdempotencyKey  string
+	SchemaVersion   string
+	// Spans is the number of spans in the batch, it isn't sent.
+	Spans int
+}
+
+// newHTTPClient returns the client of the metis server. Unless the caller passed its own client
+// or transport, it is built from the timeout, proxy and TLS options.
+func newHTTPClient(cfg *config) (*http.Client, error) {
+	if cfg.httpClient != nil {
+		return cfg.httpClient, nil
+	}
+	if cfg.transport != nil {
+		return &http.Client{Transport: cfg.transport}, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = cfg.connectTimeout
+	transport.ResponseHeaderTimeout = cfg.responseTimeout
+	if cfg.proxyURL != "" {
+		proxy, err := url.Parse(cfg.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// newTLSConfig returns the TLS configuration of the TLS options, or nil without any.
+func newTLSConfig(cfg *config) (*tls.Config, error) {
+	if cfg.caFile == "" && cfg.certFile == "" && cfg.serverName == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.serverName,
+	}
+	if cfg.caFile != "" {
+		pem, err := os.ReadFile(cfg.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		// the CA bundle adds to the system roots, the proxy and the endpoint may not share a CA
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate in CA file %s", cfg.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Export sends p with the current api key, ctx bounds the whole request.
+func (m *metisServer) Export(ctx context.Context, p *exportRequest) error {
+	apiKey, err := m.apiKey.get(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", m.url, bytes.NewReader(p.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.ContentType)
+	if p.ContentEncoding != "" {
+		req.Header.Set("Content-Encoding", p.ContentEncoding)
+	}
+	req.Header.Set("x-api-key", apiKey)
+	if p.SchemaVersion != "" {
+		req.Header.Set(schemaVersionHeader, p.SchemaVersion)
+	}
+	if p.IdempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, p.IdempotencyKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	exportErr := &ExportError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+	if errors.Is(exportErr, ErrUnauthorized) {
+		m.apiKey.reject(apiKey)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		exportErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return exportErr
+}
@@ -0,0 +1,297 @@
+package metis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolConfig configures the on-disk spool of batches that failed to export.
+type SpoolConfig struct {
+	// Dir is the spool directory, it is created when missing.
+	// Batches left there by a previous process are replayed on start.
+	Dir string
+	// MaxBytes caps the size of the spool, the oldest batches are deleted to make room.
+	MaxBytes int64
+	// ReplayInterval is the time between replays while the endpoint is unreachable.
+	ReplayInterval time.Duration
+}
+
+// DefaultSpoolConfig is the spool configuration used for zero fields.
+var DefaultSpoolConfig = SpoolConfig{
+	MaxBytes:       100 << 20, // 100MB
+	ReplayInterval: 30 * time.Second,
+}
+
+// spoolFileExt is the extension of spooled batches, temporary files don't have it.
+const spoolFileExt = ".batch"
+
+// spoolTempExt is the extension of batches being written, renamed once complete.
+const spoolTempExt = ".tmp"
+
+// spool stores export requests as files named by their spooling time, each file is the
+// JSON encoded request header on the first line followed by the request body.
+type spool struct {
+	cfg          SpoolConfig
+	ms           *metisServer
+	errorHandler ErrorHandler
+	stats        *exporterStats
+
+	mu   sync.Mutex
+	size int64
+	seq  uint64
+
+	wakeCh chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newSpool(cfg SpoolConfig, ms *metisServer, errorHandler ErrorHandler, stats *exporterStats) (*spool, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("spool directory not set")
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultSpoolConfig.MaxBytes
+	}
+	if cfg.ReplayInterval <= 0 {
+		cfg.ReplayInterval = DefaultSpoolConfig.ReplayInterval
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+	s := &spool{
+		cfg:          cfg,
+		ms:           ms,
+		errorHandler: errorHandler,
+		stats:        stats,
+		wakeCh:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	if err := s.removeTemp(); err != nil {
+		return nil, err
+	}
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		s.size += f.size
+	}
+	s.wg.Add(1)
+	go s.replayLoop()
+	return s, nil
+}
+
+type spoolFile struct {
+	path string
+	size int64
+}
+
+// removeTemp deletes the temporary files left by a process that stopped while spooling, they
+// aren't counted in the spool size and would pile up.
+func (s *spool) removeTemp() error {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("reading spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolTempExt) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.cfg.Dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing temporary spool file: %w", err)
+		}
+	}
+	return nil
+}
+
+// files returns the spooled batches, oldest first.
+func (s *spool) files() ([]spoolFile, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading spool directory: %w", err)
+	}
+	var files []spoolFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolFileExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{path: filepath.Join(s.cfg.Dir, entry.Name()), size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+// write spools req, deleting the oldest batches when the spool is full.
+func (s *spool) write(req *exportRequest) error {
+	header, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 0, len(header)+1+len(req.Body))
+	data = append(append(append(data, header...), '\n'), req.Body...)
+	if int64(len(data)) > s.cfg.MaxBytes {
+		return fmt.Errorf("batch of %d bytes exceeds the spool size", len(data))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(data)) > s.cfg.MaxBytes {
+		files, err := s.files()
+		if err != nil {
+			return err
+		}
+		dropped := 0
+		for _, f := range files {
+			if s.size+int64(len(data)) <= s.cfg.MaxBytes {
+				break
+			}
+			spans := 0
+			if old, err := readSpoolFile(f.path); err == nil {
+				spans = old.Spans
+			}
+			if s.remove(f) {
+				dropped++
+				s.stats.addSpoolFull(spans)
+			}
+		}
+		if dropped > 0 {
+			s.errorHandler.Handle(fmt.Errorf("metis: spool full, dropped %d oldest batches", dropped))
+		}
+	}
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq, spoolFileExt)
+	s.seq++
+	path := filepath.Join(s.cfg.Dir, name)
+	tmp := path + spoolTempExt
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.size += int64(len(data))
+	return nil
+}
+
+// remove deletes a spooled batch, s.mu must be held.
+func (s *spool) remove(f spoolFile) bool {
+	if err := os.Remove(f.path); err != nil {
+		return false
+	}
+	s.size -= f.size
+	return true
+}
+
+func readSpoolFile(path string) (*exportRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid spool file %s", path)
+	}
+	req := &exportRequest{}
+	if err := json.Unmarshal(data[:i], req); err != nil {
+		return nil, fmt.Errorf("invalid spool file %s: %w", path, err)
+	}
+	req.Body = data[i+1:]
+	return req, nil
+}
+
+// replay sends the spooled batches in order, until the spool is empty or a batch fails.
+func (s *spool) replay(ctx context.Context) {
+	if paused, err := s.ms.apiKey.paused(ctx); err != nil || paused {
+		// keep the batches for a valid key
+		return
+	}
+	files, err := s.files()
+	if err != nil {
+		s.errorHandler.Handle(err)
+		return
+	}
+	for _, f := range files {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		req, err := readSpoolFile(f.path)
+		if err == nil {
+			start := time.Now()
+			exportCtx, cancel := context.WithTimeout(ctx, backgroundExportTimeout)
+			err = s.ms.Export(exportCtx, req)
+			cancel()
+			if err == nil {
+				s.stats.recordUpload(req, time.Since(start), nil)
+			}
+			if err != nil && (isRetryable(err) || ctx.Err() != nil) {
+				// still unreachable or closing, try again later
+				return
+			}
+			var keyErr *APIKeyError
+			if errors.Is(err, ErrUnauthorized) || errors.As(err, &keyErr) {
+				return
+			}
+			if err != nil {
+				s.stats.addExportFailed(req.Spans)
+			}
+		}
+		if err != nil {
+			s.errorHandler.Handle(fmt.Errorf("metis: dropping spooled batch: %w", err))
+		}
+		s.mu.Lock()
+		s.remove(f)
+		s.mu.Unlock()
+	}
+}
+
+func (s *spool) replayLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.ReplayInterval)
+	defer ticker.Stop()
+	// stop the replay in flight on close
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.done
+		cancel()
+	}()
+	for {
+		s.replay(ctx)
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.wakeCh:
+		}
+	}
+}
+
+// wake replays the spool now, it is called once the endpoint answered.
+func (s *spool) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *spool) close() {
+	close(s.done)
+	s.wg.Wait()
+}
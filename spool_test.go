@@ -0,0 +1,150 @@
+package metis
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spooled returns the number of batches in the spool directory.
+func spooled(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	n := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), spoolFileExt) {
+			n++
+		}
+	}
+	return n
+}
+
+func waitForRequests(mm *metisMockServer, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for len(mm.requests()) < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return mm.requests()
+}
+
+func TestSpoolReplaysFailedBatch(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	exp, mm := newTestExporter(t,
+		WithSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour}),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(NopErrorHandler()),
+	)
+	mm.respond(http.StatusOK, http.StatusServiceUnavailable)
+	ctx := context.Background()
+
+	if err := exp.ExportSpans(ctx, newTestSpans(1)); err != nil {
+		t.Fatalf("ExportSpans() error = %v, expected the batch to be spooled", err)
+	}
+	if n := spooled(t, dir); n != 1 {
+		t.Fatalf("expected 1 spooled batch, got %d", n)
+	}
+	// a successful export replays the spool
+	if err := exp.ExportSpans(ctx, newTestSpans(1)); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if got := waitForRequests(mm, 3); len(got) != 3 || got[2] != got[0] {
+		t.Fatalf("expected the failed batch to be replayed, got %v", got)
+	}
+	mm.mu.Lock()
+	first, replayed := mm.headers[0].Get(idempotencyKeyHeader), mm.headers[2].Get(idempotencyKeyHeader)
+	mm.mu.Unlock()
+	if first == "" || first != replayed {
+		t.Errorf("expected the replay to reuse the idempotency key %q, got %q", first, replayed)
+	}
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if n := spooled(t, dir); n != 0 {
+		t.Errorf("expected the spool to be empty, got %d batches", n)
+	}
+}
+
+func TestSpoolResumesOnStart(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	exp, failing := newTestExporter(t,
+		WithSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour}),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(NopErrorHandler()),
+	)
+	failing.respond(http.StatusServiceUnavailable)
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	_, mm := newTestExporter(t, WithSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour}))
+	if got := waitForRequests(mm, 1); len(got) != 1 || !strings.Contains(got[0], "balagan/0") {
+		t.Errorf("expected the batch left by the previous exporter to be replayed, got %v", got)
+	}
+}
+
+func TestSpoolMaxBytesDropsOldest(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	exp, mm := newTestExporter(t,
+		WithSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour}),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(NopErrorHandler()),
+	)
+	mm.respond(http.StatusServiceUnavailable)
+	if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	info, err := os.ReadDir(dir)
+	if err != nil || len(info) != 1 {
+		t.Fatalf("expected 1 spooled batch, got %v, %v", info, err)
+	}
+	fi, err := info[0].Info()
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	exp.Shutdown(context.Background())
+
+	// room for two batches of the same size, the spooled batch is replayed to the failing server at once
+	exp, _ = newTestExporter(t,
+		WithSpool(SpoolConfig{Dir: dir, MaxBytes: 2*fi.Size() + 10, ReplayInterval: time.Hour}),
+		WithRetry(RetryConfig{}),
+		WithErrorHandler(NopErrorHandler()),
+		WithExporterURL(exp.ms.url),
+	)
+	for i := 0; i < 3; i++ {
+		if err := exp.ExportSpans(context.Background(), newTestSpans(1)); err != nil {
+			t.Fatalf("ExportSpans() error = %v", err)
+		}
+	}
+	if n := spooled(t, dir); n != 2 {
+		t.Errorf("expected 2 spooled batches, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, info[0].Name())); !os.IsNotExist(err) {
+		t.Errorf("expected the oldest batch to be dropped, stat error = %v", err)
+	}
+}
+
+func TestSpoolRemovesTempFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	// a process stopped between writing a batch and renaming it
+	tmp := filepath.Join(dir, "00000000000000000001-000001"+spoolFileExt+spoolTempExt)
+	if err := os.WriteFile(tmp, []byte("partial"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	newTestExporter(t, WithSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour}))
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be removed, stat error = %v", err)
+	}
+}
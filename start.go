@@ -0,0 +1,86 @@
+package metis
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Start sets up Metis tracing in a single call: it installs a tracer provider exporting to
+// Metis as the global tracer provider, and the global propagator. It accepts the same options
+// and environment variables as NewTracerProvider, ctx bounds the resource detection and the
+// signal handling of WithShutdownOnSignal.
+//
+//	shutdown, err := metis.Start(ctx, metis.WithShutdownOnSignal(5*time.Second))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer shutdown(context.Background())
+//
+// The returned shutdown flushes the spans and stops the tracer provider, it is safe to call
+// more than once and returns the result of the first call.
+func Start(ctx context.Context, opts ...Option) (shutdown func(context.Context) error, err error) {
+	cfg := newConfig(append(envOptions(), opts...))
+	tp, err := newTracerProvider(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	var once sync.Once
+	var shutdownErr error
+	shutdown = func(ctx context.Context) error {
+		once.Do(func() {
+			close(stop)
+			shutdownErr = tp.Shutdown(ctx)
+		})
+		return shutdownErr
+	}
+	if cfg.disabled {
+		// OTEL_SDK_DISABLED leaves the globals untouched
+		return shutdown, nil
+	}
+	otel.SetTracerProvider(tp)
+	if cfg.signalGrace > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		go watchSignals(ctx, signals, stop, cfg.signalGrace, shutdown, cfg.errorHandler, raise)
+	}
+	return shutdown, nil
+}
+
+// watchSignals shuts down within grace on the first signal received, then raises it again.
+// It returns without waiting for a signal once ctx is done or stop is closed.
+func watchSignals(ctx context.Context, signals chan os.Signal, stop <-chan struct{}, grace time.Duration,
+	shutdown func(context.Context) error, errorHandler ErrorHandler, raise func(os.Signal)) {
+	defer signal.Stop(signals)
+	select {
+	case <-ctx.Done():
+	case <-stop:
+	case sig := <-signals:
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			errorHandler.Handle(err)
+		}
+		signal.Stop(signals)
+		raise(sig)
+	}
+}
+
+// raise sends sig to the process again once Start stopped handling it, so that the default
+// action or the handlers of the application apply.
+func raise(sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil {
+		// signals can't be sent on every platform, exit as the default action would
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,147 @@
+package metis
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// restoreGlobals restores the globals installed by Start at the end of the test.
+func restoreGlobals(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	propagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagator)
+	})
+}
+
+func TestStart(t *testing.T) {
+	restoreGlobals(t)
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewServer(mm)
+	defer ts.Close()
+
+	shutdown, err := Start(context.Background(),
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(keepAll),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*trace.TracerProvider); !ok {
+		t.Fatalf("expected Start to install the tracer provider, got %T", otel.GetTracerProvider())
+	}
+	_, span := otel.Tracer("balagan").Start(context.Background(), "gadol")
+	span.End()
+
+	// shutdown is safe to call concurrently and more than once
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("shutdown() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(mm.spans) != 1 {
+		t.Errorf("expected the span to be flushed once, got %d requests", len(mm.spans))
+	}
+}
+
+func TestStartError(t *testing.T) {
+	t.Setenv("METIS_API_KEY", "")
+	restoreGlobals(t)
+	tp := otel.GetTracerProvider()
+	if _, err := Start(context.Background()); err == nil {
+		t.Fatal("expected Start to fail without api key")
+	}
+	if otel.GetTracerProvider() != tp {
+		t.Error("expected the globals to be left untouched")
+	}
+}
+
+func TestStartDisabled(t *testing.T) {
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	restoreGlobals(t)
+	tp := otel.GetTracerProvider()
+	shutdown, err := Start(context.Background(), WithShutdownOnSignal(time.Second))
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if otel.GetTracerProvider() != tp {
+		t.Error("expected a disabled SDK to leave the globals untouched")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func TestWatchSignals(t *testing.T) {
+	t.Parallel()
+	signals := make(chan os.Signal, 1)
+	var calls []string
+	var deadline time.Time
+	shutdown := func(ctx context.Context) error {
+		calls = append(calls, "shutdown")
+		deadline, _ = ctx.Deadline()
+		return errors.New("shutdown abandoned 1 spans")
+	}
+	var handled []error
+	handler := ErrorHandlerFunc(func(err error) { handled = append(handled, err) })
+	raised := make(chan os.Signal, 1)
+
+	signals <- syscall.SIGTERM
+	start := time.Now()
+	watchSignals(context.Background(), signals, nil, 5*time.Second, shutdown, handler, func(sig os.Signal) {
+		calls = append(calls, "raise")
+		raised <- sig
+	})
+	if len(calls) != 2 || calls[0] != "shutdown" || calls[1] != "raise" {
+		t.Errorf("expected shutdown then raise, got %v", calls)
+	}
+	if deadline.Before(start.Add(5*time.Second)) || deadline.After(time.Now().Add(5*time.Second)) {
+		t.Errorf("expected a 5s grace period, got %v", deadline.Sub(start))
+	}
+	if sig := <-raised; sig != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM to be raised again, got %v", sig)
+	}
+	if len(handled) != 1 {
+		t.Errorf("expected the shutdown error to be reported, got %v", handled)
+	}
+}
+
+func TestWatchSignalsStopped(t *testing.T) {
+	t.Parallel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopped := make(chan struct{})
+	close(stopped)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		stop chan struct{}
+	}{
+		{name: "context done", ctx: canceled},
+		{name: "shutdown called", ctx: context.Background(), stop: stopped},
+	}
+	for _, tt := range tests {
+		fail := func() { t.Errorf("%s: expected no shutdown", tt.name) }
+		watchSignals(tt.ctx, make(chan os.Signal, 1), tt.stop, time.Second,
+			func(context.Context) error { fail(); return nil },
+			NopErrorHandler(),
+			func(os.Signal) { fail() })
+	}
+}
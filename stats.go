@@ -0,0 +1,247 @@
+package metis
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// instrumentationName is the name of the meter recording the exporter metrics.
+const instrumentationName = "github.com/metis-data/go-interceptor"
+
+// droppedReasonKey is the attribute of the dropped spans metric giving the reason they were dropped.
+const droppedReasonKey = attribute.Key("metis.dropped.reason")
+
+// Reasons spans are dropped for.
+var (
+	reasonQueueFull    = metric.WithAttributes(droppedReasonKey.String("queue_full"))
+	reasonSpoolFull    = metric.WithAttributes(droppedReasonKey.String("spool_full"))
+	reasonExportFailed = metric.WithAttributes(droppedReasonKey.String("export_failed"))
+	reasonOversized    = metric.WithAttributes(droppedReasonKey.String("oversized"))
+	reasonAbandoned    = metric.WithAttributes(droppedReasonKey.String("abandoned"))
+	reasonShutdown     = metric.WithAttributes(droppedReasonKey.String("after_shutdown"))
+)
+
+// Stats is a snapshot of the exporter counters since it was created.
+type Stats struct {
+	// SpansReceived counts the spans passed to ExportSpans.
+	SpansReceived uint64
+	// SpansFiltered counts the spans left out by the span filter or tail sampling.
+	SpansFiltered uint64
+	// SpansExported counts the spans of the batches accepted by the endpoint.
+	SpansExported uint64
+	// SpansTruncated counts the spans whose attributes were truncated to fit the batch byte limit.
+	SpansTruncated uint64
+	// Dropped counts the dropped spans by reason.
+	Dropped DroppedSpans
+	// BatchesSent counts the batches accepted by the endpoint.
+	BatchesSent uint64
+	// BatchesFailed counts the batches that failed to upload after retries, spooled or not.
+	BatchesFailed uint64
+	// BytesSent counts the request body bytes of the batches accepted by the endpoint.
+	BytesSent uint64
+	// QueuedSpans is the number of spans waiting in the export queue.
+	QueuedSpans int
+	// LastExport is the time of the last batch accepted by the endpoint, zero if none was.
+	LastExport time.Time
+}
+
+// StatsReader reads the Stats of the exporter created with WithStatsReader, which is hidden
+// behind the tracer provider returned by NewTracerProvider or Start:
+//
+//	stats := new(metis.StatsReader)
+//	tp, err := metis.NewTracerProvider(metis.WithStatsReader(stats))
+//	...
+//	log.Printf("dropped spans: %+v", stats.Stats().Dropped)
+type StatsReader struct {
+	exporter atomic.Pointer[Exporter]
+}
+
+// Stats returns a snapshot of the exporter counters, the zero Stats before the exporter is created.
+func (r *StatsReader) Stats() Stats {
+	if exporter := r.exporter.Load(); exporter != nil {
+		return exporter.Stats()
+	}
+	return Stats{}
+}
+
+// DroppedSpans counts the spans the exporter dropped, by reason.
+type DroppedSpans struct {
+	// QueueFull counts the spans dropped by the overflow policy of the export queue.
+	QueueFull uint64
+	// SpoolFull counts the spans of the spooled batches deleted to make room in the spool.
+	SpoolFull uint64
+	// ExportFailed counts the spans of the batches that failed to export and weren't spooled.
+	ExportFailed uint64
+	// Oversized counts the spans exceeding the batch byte limit even with truncated attributes.
+	Oversized uint64
+	// Abandoned counts the spans whose export context was done before they were uploaded,
+	// for example at the Shutdown deadline.
+	Abandoned uint64
+	// AfterShutdown counts the spans passed to ExportSpans after Shutdown.
+	AfterShutdown uint64
+}
+
+// exporterStats keeps the exporter counters and records them as OpenTelemetry metrics.
+type exporterStats struct {
+	spansReceived       atomic.Uint64
+	spansFiltered       atomic.Uint64
+	spansExported       atomic.Uint64
+	spansTruncated      atomic.Uint64
+	droppedQueueFull    atomic.Uint64
+	droppedSpoolFull    atomic.Uint64
+	droppedExportFailed atomic.Uint64
+	droppedOversized    atomic.Uint64
+	droppedAbandoned    atomic.Uint64
+	droppedShutdown     atomic.Uint64
+	batchesSent         atomic.Uint64
+	batchesFailed       atomic.Uint64
+	bytesSent           atomic.Uint64
+	lastExport          atomic.Int64
+
+	received       metric.Int64Counter
+	filtered       metric.Int64Counter
+	exported       metric.Int64Counter
+	truncated      metric.Int64Counter
+	dropped        metric.Int64Counter
+	batches        metric.Int64Counter
+	failed         metric.Int64Counter
+	bytes          metric.Int64Counter
+	exportDuration metric.Float64Histogram
+}
+
+func newExporterStats(mp metric.MeterProvider) (*exporterStats, error) {
+	if mp == nil {
+		mp = noop.NewMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	s := &exporterStats{}
+	var err error
+	counters := []struct {
+		counter     *metric.Int64Counter
+		name        string
+		unit        string
+		description string
+	}{
+		{&s.received, "metis.exporter.spans.received", "{span}", "Spans passed to the exporter."},
+		{&s.filtered, "metis.exporter.spans.filtered", "{span}", "Spans left out by the span filter or tail sampling."},
+		{&s.exported, "metis.exporter.spans.exported", "{span}", "Spans accepted by the endpoint."},
+		{&s.truncated, "metis.exporter.spans.truncated", "{span}", "Spans whose attributes were truncated to fit the batch byte limit."},
+		{&s.dropped, "metis.exporter.spans.dropped", "{span}", "Spans dropped by the exporter, by reason."},
+		{&s.batches, "metis.exporter.batches.sent", "{batch}", "Batches accepted by the endpoint."},
+		{&s.failed, "metis.exporter.batches.failed", "{batch}", "Batches that failed to upload after retries."},
+		{&s.bytes, "metis.exporter.bytes.sent", "By", "Request body bytes accepted by the endpoint."},
+	}
+	for _, c := range counters {
+		*c.counter, err = meter.Int64Counter(c.name, metric.WithUnit(c.unit), metric.WithDescription(c.description))
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.exportDuration, err = meter.Float64Histogram("metis.exporter.export.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of batch uploads, retries included."))
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *exporterStats) addReceived(n int) {
+	s.spansReceived.Add(uint64(n))
+	s.received.Add(context.Background(), int64(n))
+}
+
+func (s *exporterStats) addFiltered(n int) {
+	if n == 0 {
+		return
+	}
+	s.spansFiltered.Add(uint64(n))
+	s.filtered.Add(context.Background(), int64(n))
+}
+
+func (s *exporterStats) addTruncated(n int) {
+	s.spansTruncated.Add(uint64(n))
+	s.truncated.Add(context.Background(), int64(n))
+}
+
+func (s *exporterStats) addDropped(counter *atomic.Uint64, reason metric.AddOption, n int) {
+	if n == 0 {
+		return
+	}
+	counter.Add(uint64(n))
+	s.dropped.Add(context.Background(), int64(n), reason)
+}
+
+func (s *exporterStats) addQueueFull(n int) {
+	s.addDropped(&s.droppedQueueFull, reasonQueueFull, n)
+}
+
+func (s *exporterStats) addSpoolFull(n int) {
+	s.addDropped(&s.droppedSpoolFull, reasonSpoolFull, n)
+}
+
+func (s *exporterStats) addExportFailed(n int) {
+	s.addDropped(&s.droppedExportFailed, reasonExportFailed, n)
+}
+
+func (s *exporterStats) addOversized(n int) {
+	s.addDropped(&s.droppedOversized, reasonOversized, n)
+}
+
+func (s *exporterStats) addAbandoned(n int) {
+	s.addDropped(&s.droppedAbandoned, reasonAbandoned, n)
+}
+
+func (s *exporterStats) addAfterShutdown(n int) {
+	s.addDropped(&s.droppedShutdown, reasonShutdown, n)
+}
+
+// recordUpload records an upload of req that took d.
+func (s *exporterStats) recordUpload(req *exportRequest, d time.Duration, err error) {
+	ctx := context.Background()
+	s.exportDuration.Record(ctx, d.Seconds())
+	if err != nil {
+		s.batchesFailed.Add(1)
+		s.failed.Add(ctx, 1)
+		return
+	}
+	s.spansExported.Add(uint64(req.Spans))
+	s.exported.Add(ctx, int64(req.Spans))
+	s.batchesSent.Add(1)
+	s.batches.Add(ctx, 1)
+	s.bytesSent.Add(uint64(len(req.Body)))
+	s.bytes.Add(ctx, int64(len(req.Body)))
+	s.lastExport.Store(time.Now().UnixNano())
+}
+
+func (s *exporterStats) droppedSpans() DroppedSpans {
+	return DroppedSpans{
+		QueueFull:     s.droppedQueueFull.Load(),
+		SpoolFull:     s.droppedSpoolFull.Load(),
+		ExportFailed:  s.droppedExportFailed.Load(),
+		Oversized:     s.droppedOversized.Load(),
+		Abandoned:     s.droppedAbandoned.Load(),
+		AfterShutdown: s.droppedShutdown.Load(),
+	}
+}
+
+func (s *exporterStats) snapshot() Stats {
+	stats := Stats{
+		SpansReceived:  s.spansReceived.Load(),
+		SpansFiltered:  s.spansFiltered.Load(),
+		SpansExported:  s.spansExported.Load(),
+		SpansTruncated: s.spansTruncated.Load(),
+		Dropped:        s.droppedSpans(),
+		BatchesSent:    s.batchesSent.Load(),
+		BatchesFailed:  s.batchesFailed.Load(),
+		BytesSent:      s.bytesSent.Load(),
+	}
+	if lastExport := s.lastExport.Load(); lastExport != 0 {
+		stats.LastExport = time.Unix(0, lastExport)
+	}
+	return stats
+}
@@ -0,0 +1,128 @@
+package metis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExporterStats(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t, responses: []int{http.StatusOK, http.StatusBadRequest}}
+	ts := httptest.NewServer(http.HandlerFunc(mm.ServeHTTP))
+	defer ts.Close()
+	reader := sdkmetric.NewManualReader()
+	exp, err := NewExporter(
+		WithExporterURL(ts.URL),
+		WithAPIKey("test-api-key"),
+		WithSpanFilter(HTTPServerSpans()),
+		WithErrorHandler(NopErrorHandler()),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	ctx := context.Background()
+	spans := append(newTestSpans(3), tracetest.SpanStubs{{Name: "internal"}}.Snapshots()...)
+	if err := exp.ExportSpans(ctx, spans); err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	// rejected by the endpoint
+	_ = exp.ExportSpans(ctx, newTestSpans(2))
+
+	stats := exp.Stats()
+	want := Stats{
+		SpansReceived: 6,
+		SpansFiltered: 1,
+		SpansExported: 3,
+		Dropped:       DroppedSpans{ExportFailed: 2},
+		BatchesSent:   1,
+		BatchesFailed: 1,
+	}
+	if stats.BytesSent == 0 || stats.LastExport.IsZero() {
+		t.Errorf("expected bytes sent and last export time, got %+v", stats)
+	}
+	stats.BytesSent, stats.LastExport = 0, want.LastExport
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	sums := map[string]int64{}
+	var uploads uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					uploads += dp.Count
+				}
+			}
+		}
+	}
+	for name, value := range map[string]int64{
+		"metis.exporter.spans.received": 6,
+		"metis.exporter.spans.filtered": 1,
+		"metis.exporter.spans.exported": 3,
+		"metis.exporter.spans.dropped":  2,
+		"metis.exporter.batches.sent":   1,
+		"metis.exporter.batches.failed": 1,
+		"metis.exporter.bytes.sent":     int64(exp.Stats().BytesSent),
+	} {
+		if sums[name] != value {
+			t.Errorf("expected %s = %d, got %d", name, value, sums[name])
+		}
+	}
+	if uploads != 2 {
+		t.Errorf("expected 2 recorded upload durations, got %d", uploads)
+	}
+}
+
+func TestExporterStatsQueued(t *testing.T) {
+	t.Parallel()
+	exp, mm := newTestExporter(t, WithExportQueue(ExportQueueConfig{Workers: 1}))
+	received, release := mm.block()
+	exportNamed(t, exp, "first")
+	<-received
+	exportNamed(t, exp, "second", "third")
+	if got := exp.Stats().QueuedSpans; got != 2 {
+		t.Errorf("expected 2 queued spans, got %d", got)
+	}
+	close(release)
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if got := exp.Stats(); got.QueuedSpans != 0 || got.SpansExported != 3 {
+		t.Errorf("expected every span to be exported, got %+v", got)
+	}
+}
+
+func TestStatsReader(t *testing.T) {
+	stats := new(StatsReader)
+	if got := stats.Stats(); got != (Stats{}) {
+		t.Errorf("expected zero stats before the tracer provider is created, got %+v", got)
+	}
+	tp, mm := newTestTracerProvider(t, WithStatsReader(stats))
+	endSpans(tp, 3)
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("tp.ForceFlush() error = %v", err)
+	}
+	if got := stats.Stats(); got.SpansReceived != 3 || got.SpansExported != 3 || got.BatchesSent != uint64(len(mm.requests())) {
+		t.Errorf("expected 3 exported spans, got %+v", got)
+	}
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("tp.Shutdown() error = %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package metis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writePEM writes the PEM block of der to a file of dir and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	return path
+}
+
+// writeServerCA writes the certificate of the TLS server ts to a CA file.
+func writeServerCA(t *testing.T, ts *httptest.Server) string {
+	return writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+}
+
+// newClientCertificate writes a self-signed client certificate and its key to files.
+func newClientCertificate(t *testing.T) (cert *x509.Certificate, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metis-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+	dir := t.TempDir()
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// exportOne exports a span with an exporter of opts, without retries.
+func exportOne(t *testing.T, url string, opts ...Option) error {
+	opts = append([]Option{
+		WithExporterURL(url),
+		WithAPIKey("test-api-key"),
+		WithErrorHandler(NopErrorHandler()),
+		WithRetry(RetryConfig{}),
+	}, opts...)
+	exp, err := NewExporter(opts...)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	return exp.ExportSpans(context.Background(), newTestSpans(1))
+}
+
+func TestExporterCAFile(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewTLSServer(mm)
+	defer ts.Close()
+
+	if err := exportOne(t, ts.URL); err == nil {
+		t.Error("expected an unknown authority error without the CA file")
+	}
+	if err := exportOne(t, ts.URL, WithCAFile(writeServerCA(t, ts))); err != nil {
+		t.Errorf("ExportSpans() error = %v", err)
+	}
+}
+
+func TestExporterInvalidTLSFiles(t *testing.T) {
+	t.Parallel()
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "missing CA file", opt: WithCAFile(filepath.Join(t.TempDir(), "missing.pem"))},
+		{name: "CA file without PEM", opt: WithCAFile(notPEM)},
+		{name: "invalid client certificate", opt: WithClientCertificate(notPEM, notPEM)},
+		{name: "invalid proxy", opt: WithProxy("http://[::1")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := NewExporter(WithAPIKey("test-api-key"), tt.opt); err == nil {
+				t.Error("expected NewExporter() to fail")
+			}
+		})
+	}
+}
+
+func TestExporterServerName(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewTLSServer(mm)
+	defer ts.Close()
+	ca := WithCAFile(writeServerCA(t, ts))
+
+	// the test certificate is valid for example.com
+	if err := exportOne(t, ts.URL, ca, WithServerName("example.com")); err != nil {
+		t.Errorf("ExportSpans() error = %v", err)
+	}
+	if err := exportOne(t, ts.URL, ca, WithServerName("metis.invalid")); err == nil {
+		t.Error("expected a certificate name mismatch")
+	}
+}
+
+func TestExporterClientCertificate(t *testing.T) {
+	t.Parallel()
+	cert, certFile, keyFile := newClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewUnstartedServer(mm)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+	ca := WithCAFile(writeServerCA(t, ts))
+
+	if err := exportOne(t, ts.URL, ca); err == nil {
+		t.Error("expected the handshake to fail without a client certificate")
+	}
+	if err := exportOne(t, ts.URL, ca, WithClientCertificate(certFile, keyFile)); err != nil {
+		t.Errorf("ExportSpans() error = %v", err)
+	}
+}
+
+func TestExporterHTTPClient(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewTLSServer(mm)
+	defer ts.Close()
+
+	// the client of the test server trusts its certificate, the CA option is ignored
+	if err := exportOne(t, ts.URL, WithHTTPClient(ts.Client()), WithCAFile("missing.pem")); err != nil {
+		t.Errorf("ExportSpans() error = %v", err)
+	}
+}
+
+// countingTransport counts the requests it sends.
+type countingTransport struct {
+	next     http.RoundTripper
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return c.next.RoundTrip(r)
+}
+
+func TestExporterTransport(t *testing.T) {
+	t.Parallel()
+	mm := &metisMockServer{t: t}
+	ts := httptest.NewTLSServer(mm)
+	defer ts.Close()
+
+	transport := &countingTransport{next: ts.Client().Transport}
+	if err := exportOne(t, ts.URL, WithTransport(transport)); err != nil {
+		t.Errorf("ExportSpans() error = %v", err)
+	}
+	if got := transport.requests.Load(); got != 1 {
+		t.Errorf("expected 1 request through the transport, got %d", got)
+	}
+}
+
+func TestExporterProxy(t *testing.T) {
+	t.Parallel()
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy receives the absolute url of the request
+		proxied.Store(r.URL.String())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	if err := exportOne(t, "http://metis.invalid/ingest", WithProxy(proxy.URL)); err != nil {
+		t.Errorf("ExportSpans() error = %v", err)
+	}
+	if got, _ := proxied.Load().(string); got != "http://metis.invalid/ingest" {
+		t.Errorf("expected the upload to go through the proxy, got %q", got)
+	}
+}